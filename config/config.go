@@ -0,0 +1,180 @@
+// Package config reads mermgen settings from a mermgen.yaml or .mermgen.toml
+// file committed next to the code, and applies them to the command line flags
+// that were not set otherwise.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// FileNames are the configuration files looked up by Find, in order of
+// preference
+var FileNames = []string{"mermgen.yaml", "mermgen.yml", ".mermgen.toml"}
+
+// File is the content of a mermgen.yaml or .mermgen.toml file. Every setting
+// has a command line flag of the same meaning, which overrides it. Unset
+// settings leave the flag's default in place.
+type File struct {
+	Provider      string   `yaml:"provider" toml:"provider"`
+	Model         string   `yaml:"model" toml:"model"`
+	BaseURL       string   `yaml:"base_url" toml:"base_url"`
+	Diagrams      []string `yaml:"diagrams" toml:"diagrams"`
+	Deterministic *bool    `yaml:"deterministic" toml:"deterministic"`
+	Fallback      string   `yaml:"fallback" toml:"fallback"`
+	Backend       string   `yaml:"backend" toml:"backend"`
+	External      string   `yaml:"external" toml:"external"`
+
+	Include  []string `yaml:"include" toml:"include"`
+	Exclude  []string `yaml:"exclude" toml:"exclude"`
+	AllFiles *bool    `yaml:"all_files" toml:"all_files"`
+
+	Output struct {
+		// Dir is resolved against the directory of the configuration file
+		Dir       string `yaml:"dir" toml:"dir"`
+		PerModule *bool  `yaml:"per_module" toml:"per_module"`
+	} `yaml:"output" toml:"output"`
+
+	Tokens struct {
+		Prompt  *int `yaml:"prompt" toml:"prompt"`
+		Summary *int `yaml:"summary" toml:"summary"`
+		Diagram *int `yaml:"diagram" toml:"diagram"`
+	} `yaml:"tokens" toml:"tokens"`
+}
+
+// Find returns the first configuration file in dirs, or "" if there is none
+func Find(dirs ...string) string {
+	for _, dir := range dirs {
+		for _, name := range FileNames {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// Load reads a YAML or TOML configuration file, chosen by its extension.
+// Unknown keys are errors, so typos do not go unnoticed.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	file := &File{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("error parsing %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), file)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("error parsing %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file %s (expected .yaml, .yml or .toml)", path)
+	}
+
+	// The output directory belongs to the project the file describes, not to
+	// wherever mermgen happens to run
+	if file.Output.Dir != "" && !filepath.IsAbs(file.Output.Dir) {
+		file.Output.Dir = filepath.Join(filepath.Dir(path), file.Output.Dir)
+	}
+	return file, nil
+}
+
+// FlagValues returns the settings of the file as values of the matching
+// flags, leaving out settings that are not set
+func (f *File) FlagValues() map[string]string {
+	values := make(map[string]string)
+	setString := func(name, value string) {
+		if value != "" {
+			values[name] = value
+		}
+	}
+	setList := func(name string, value []string) {
+		if len(value) > 0 {
+			values[name] = strings.Join(value, ",")
+		}
+	}
+	setBool := func(name string, value *bool) {
+		if value != nil {
+			values[name] = strconv.FormatBool(*value)
+		}
+	}
+	setInt := func(name string, value *int) {
+		if value != nil {
+			values[name] = strconv.Itoa(*value)
+		}
+	}
+
+	setString("provider", f.Provider)
+	setString("model", f.Model)
+	setString("base-url", f.BaseURL)
+	setList("diagram", f.Diagrams)
+	setBool("deterministic", f.Deterministic)
+	setString("fallback", f.Fallback)
+	setString("backend", f.Backend)
+	setString("external", f.External)
+	setList("include", f.Include)
+	setList("exclude", f.Exclude)
+	setBool("all-files", f.AllFiles)
+	setString("output", f.Output.Dir)
+	setBool("per-module", f.Output.PerModule)
+	setInt("prompt-tokens", f.Tokens.Prompt)
+	setInt("summary-tokens", f.Tokens.Summary)
+	setInt("diagram-tokens", f.Tokens.Diagram)
+	return values
+}
+
+// Apply sets the flags that were neither given on the command line nor
+// through an environment variable to the values of the file. envFlags maps
+// flags to the variables their defaults are read from; a variable that is
+// set takes precedence over the file.
+func (f *File) Apply(flags *flag.FlagSet, envFlags map[string]string) error {
+	explicit := make(map[string]bool)
+	flags.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = true
+	})
+
+	for name, value := range f.FlagValues() {
+		if explicit[name] {
+			continue
+		}
+		if env, ok := envFlags[name]; ok && os.Getenv(env) != "" {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid config value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// LoadEnvFile loads environment variables from an env file if it exists
+func LoadEnvFile(path string) error {
+	if err := godotenv.Load(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error loading %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to name in dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		err     string // Expected error substring, empty for success
+		values  map[string]string
+	}{
+		{
+			name: "yaml",
+			file: "mermgen.yaml",
+			content: `provider: openai
+diagrams: [class, package]
+deterministic: false
+include: ["internal/**"]
+output:
+  dir: docs/diagrams
+  per_module: true
+tokens:
+  prompt: 50000
+  diagram: 0
+`,
+			values: map[string]string{
+				"provider":       "openai",
+				"diagram":        "class,package",
+				"deterministic":  "false",
+				"include":        "internal/**",
+				"output":         "docs/diagrams",
+				"per-module":     "true",
+				"prompt-tokens":  "50000",
+				"diagram-tokens": "0",
+			},
+		},
+		{
+			name: "toml",
+			file: ".mermgen.toml",
+			content: `model = "llama3"
+exclude = ["legacy/**"]
+
+[tokens]
+summary = 2048
+`,
+			values: map[string]string{
+				"model":          "llama3",
+				"exclude":        "legacy/**",
+				"summary-tokens": "2048",
+			},
+		},
+		{name: "empty yaml", file: "mermgen.yml", content: "", values: map[string]string{}},
+		{name: "unknown yaml key", file: "mermgen.yaml", content: "diagram: [class]\n", err: "field diagram not found"},
+		{name: "unknown nested yaml key", file: "mermgen.yaml", content: "output:\n  directory: out\n", err: "field directory not found"},
+		{name: "unknown toml key", file: ".mermgen.toml", content: "[tokens]\nprompts = 1\n", err: "unknown key tokens.prompts"},
+		{name: "invalid yaml", file: "mermgen.yaml", content: "diagrams: class: package\n", err: "error parsing"},
+		{name: "unsupported extension", file: "mermgen.json", content: "{}", err: "unsupported config file"},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		file, err := Load(writeFile(t, dir, test.file, test.content))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to load: %v", test.name, err)
+			continue
+		}
+
+		values := file.FlagValues()
+		if output, ok := test.values["output"]; ok {
+			// Relative output directories are resolved against the file
+			test.values["output"] = filepath.Join(dir, output)
+		}
+		if len(values) != len(test.values) {
+			t.Errorf("%s: expected values %v, got %v", test.name, test.values, values)
+		}
+		for name, want := range test.values {
+			if values[name] != want {
+				t.Errorf("%s: expected %s=%q, got %q", test.name, name, want, values[name])
+			}
+		}
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "mermgen.yaml")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestApply(t *testing.T) {
+	zero := 0
+	enabled := true
+	file := &File{Provider: "openai", Model: "file-model", Deterministic: &enabled}
+	file.Tokens.Prompt = &zero
+
+	tests := []struct {
+		name string
+		args []string
+		env  string // Value of MERMGEN_MODEL
+		want map[string]string
+	}{
+		{
+			name: "file over defaults",
+			want: map[string]string{"provider": "openai", "model": "file-model", "deterministic": "true", "prompt-tokens": "0", "output": "diagrams"},
+		},
+		{
+			name: "flags over file",
+			args: []string{"-provider", "gemini", "-deterministic=false", "-prompt-tokens", "10"},
+			want: map[string]string{"provider": "gemini", "model": "file-model", "deterministic": "false", "prompt-tokens": "10"},
+		},
+		{
+			name: "environment over file",
+			env:  "env-model",
+			want: map[string]string{"provider": "openai", "model": "env-model"},
+		},
+		{
+			name: "flags over environment",
+			args: []string{"-model", "flag-model"},
+			env:  "env-model",
+			want: map[string]string{"model": "flag-model"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Setenv("MERMGEN_MODEL", test.env)
+		flags := flag.NewFlagSet("mermgen", flag.ContinueOnError)
+		flags.String("provider", "anthropic", "")
+		flags.String("model", os.Getenv("MERMGEN_MODEL"), "")
+		flags.Bool("deterministic", false, "")
+		flags.Int("prompt-tokens", 100000, "")
+		flags.String("output", "diagrams", "")
+		if err := flags.Parse(test.args); err != nil {
+			t.Fatalf("%s: failed to parse flags: %v", test.name, err)
+		}
+
+		if err := file.Apply(flags, map[string]string{"model": "MERMGEN_MODEL"}); err != nil {
+			t.Errorf("%s: failed to apply: %v", test.name, err)
+			continue
+		}
+		for name, want := range test.want {
+			if got := flags.Lookup(name).Value.String(); got != want {
+				t.Errorf("%s: expected %s=%q, got %q", test.name, name, want, got)
+			}
+		}
+	}
+
+	// Values the flag rejects are reported with the flag's name
+	flags := flag.NewFlagSet("mermgen", flag.ContinueOnError)
+	flags.Int("prompt-tokens", 100000, "")
+	flags.Bool("deterministic", false, "")
+	bad := &File{}
+	bad.Tokens.Prompt = &zero
+	bad.Provider = "openai" // No such flag in this set
+	if err := bad.Apply(flags, nil); err == nil || !strings.Contains(err.Error(), "provider") {
+		t.Errorf("Expected an error for an unknown flag, got %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	empty, project := t.TempDir(), t.TempDir()
+	if path := Find(empty); path != "" {
+		t.Errorf("Expected no config file, got %s", path)
+	}
+
+	toml := writeFile(t, project, ".mermgen.toml", "")
+	if path := Find(empty, project); path != toml {
+		t.Errorf("Expected %s, got %s", toml, path)
+	}
+	yaml := writeFile(t, project, "mermgen.yaml", "")
+	if path := Find(project); path != yaml {
+		t.Errorf("Expected YAML to be preferred, got %s", path)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := LoadEnvFile(filepath.Join(dir, ".env.local")); err != nil {
+		t.Errorf("Expected a missing env file to be ignored, got %v", err)
+	}
+
+	t.Setenv("MERMGEN_TEST_VALUE", "")
+	os.Unsetenv("MERMGEN_TEST_VALUE")
+	path := writeFile(t, dir, ".env.local", "MERMGEN_TEST_VALUE=from-file\n")
+	if err := LoadEnvFile(path); err != nil {
+		t.Fatalf("Failed to load env file: %v", err)
+	}
+	if value := os.Getenv("MERMGEN_TEST_VALUE"); value != "from-file" {
+		t.Errorf("Expected the variable from the env file, got %q", value)
+	}
+
+	if err := LoadEnvFile(dir); err == nil {
+		t.Error("Expected an error for an unreadable env file")
+	}
+}
@@ -0,0 +1,102 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultAnthropicModel   = "claude-3-7-sonnet-20250219"
+	defaultAnthropicBaseURL = "https://api.anthropic.com/v1"
+	anthropicThinkingBudget = 16000
+)
+
+// anthropicProvider talks to Anthropic's Messages API with extended thinking enabled
+type anthropicProvider struct {
+	apiKey  string
+	model   string
+	baseURL string
+}
+
+func newAnthropicProvider(cfg ProviderConfig) (*anthropicProvider, error) {
+	apiKey := cfg.APIKey
+	if apiKey == "" {
+		apiKey = firstEnv("ANTHROPIC_API_KEY")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("anthropic: %w (set ANTHROPIC_API_KEY)", ErrMissingAPIKey)
+	}
+
+	provider := &anthropicProvider{
+		apiKey:  apiKey,
+		model:   cfg.Model,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+	}
+	if provider.model == "" {
+		provider.model = defaultAnthropicModel
+	}
+	if provider.baseURL == "" {
+		provider.baseURL = defaultAnthropicBaseURL
+	}
+	return provider, nil
+}
+
+func (p *anthropicProvider) Name() string  { return "anthropic" }
+func (p *anthropicProvider) Model() string { return p.model }
+
+func (p *anthropicProvider) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = 64000
+	}
+
+	requestBody := map[string]interface{}{
+		"model":       p.model,
+		"max_tokens":  maxTokens,
+		"temperature": 1,
+		"system":      req.System,
+		"messages": []map[string]interface{}{
+			{
+				"role":    "user",
+				"content": req.Prompt,
+			},
+		},
+	}
+	// Thinking tokens count towards max_tokens, so short responses such as
+	// package summaries are requested without thinking
+	if maxTokens > anthropicThinkingBudget {
+		requestBody["thinking"] = map[string]interface{}{
+			"type":          "enabled",
+			"budget_tokens": anthropicThinkingBudget,
+		}
+	}
+
+	headers := map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": "2023-06-01",
+	}
+	responseBody, header, err := postJSON(ctx, p.baseURL+"/messages", headers, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return nil, fmt.Errorf("error parsing response: %w", err)
+	}
+
+	// Skip thinking blocks and return the first text block
+	for _, part := range response.Content {
+		if part.Type == "text" {
+			return &CompletionResponse{Text: part.Text, Header: header}, nil
+		}
+	}
+	return &CompletionResponse{Header: header}, nil
+}
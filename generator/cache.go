@@ -0,0 +1,116 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/Nurozen/mermgen/internal/diskcache"
+)
+
+// ResponseCache stores provider responses on disk, addressed by a hash of
+// everything that determines the response: provider, model, system prompt,
+// prompt and token limit. Regenerating diagrams of an unchanged repository
+// is then answered entirely from the cache.
+type ResponseCache struct {
+	Dir string
+
+	// Refresh skips lookups, so every completion is requested again and
+	// replaces the cached response
+	Refresh bool
+}
+
+// cacheEntry is the file format of a cached response
+type cacheEntry struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	Text     string `json:"text"`
+}
+
+// DefaultCacheDir returns the cache directory used when none is configured,
+// inside the user's cache directory
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "mermgen", "responses"), nil
+}
+
+// Clear removes every cached response
+func (c *ResponseCache) Clear() error {
+	if err := os.RemoveAll(c.Dir); err != nil {
+		return fmt.Errorf("error clearing response cache: %w", err)
+	}
+	return nil
+}
+
+// key hashes the inputs of a completion
+func (c *ResponseCache) key(provider Provider, request CompletionRequest) string {
+	input, _ := json.Marshal(struct {
+		Provider  string
+		Model     string
+		System    string
+		Prompt    string
+		MaxTokens int
+	}{provider.Name(), provider.Model(), request.System, request.Prompt, request.MaxTokens})
+	sum := sha256.Sum256(input)
+	return hex.EncodeToString(sum[:])
+}
+
+// path returns the file of the entry for key
+func (c *ResponseCache) path(key string) string {
+	return diskcache.Path(c.Dir, key, ".json")
+}
+
+// Get returns the cached response text of a completion
+func (c *ResponseCache) Get(provider Provider, request CompletionRequest) (string, bool) {
+	if c.Refresh {
+		return "", false
+	}
+	data, err := os.ReadFile(c.path(c.key(provider, request)))
+	if err != nil {
+		return "", false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Text == "" {
+		return "", false
+	}
+	return entry.Text, true
+}
+
+// Put stores the response text of a completion
+func (c *ResponseCache) Put(provider Provider, request CompletionRequest, text string) error {
+	data, err := json.Marshal(cacheEntry{Provider: provider.Name(), Model: provider.Model(), Text: text})
+	if err != nil {
+		return fmt.Errorf("error marshaling cache entry: %w", err)
+	}
+	return diskcache.WriteFile(c.path(c.key(provider, request)), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// lookup is Get on a cache that may be nil
+func (c *ResponseCache) lookup(provider Provider, request CompletionRequest) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	return c.Get(provider, request)
+}
+
+// store is Put on a cache that may be nil. Failing to cache a response only
+// costs requesting it again on the next run.
+func (c *ResponseCache) store(provider Provider, request CompletionRequest, text string, logger *slog.Logger) {
+	if c == nil {
+		return
+	}
+	if err := c.Put(provider, request, text); err != nil {
+		logger.Warn("Failed to cache response", "error", err)
+	}
+}
@@ -0,0 +1,427 @@
+package generator
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Nurozen/mermgen/parser"
+)
+
+// classInfo is a type declaration together with the context needed to
+// resolve the types it refers to
+type classInfo struct {
+	id      string
+	pkgKey  string // Directory of the declaring package
+	pkgName string
+	decl    parser.TypeDecl
+	methods []parser.Func
+	imports map[string]string // Import paths of the declaring file by the name it uses
+}
+
+// classEdge is a relationship between two classes, stored in the order
+// it is written in the diagram
+type classEdge struct {
+	left   string
+	arrow  string
+	right  string
+	labels []string
+}
+
+// typeIdentPattern matches plain and package-qualified identifiers in a type expression
+var typeIdentPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?`)
+
+// renderClassDiagram builds a Mermaid class diagram directly from the parsed
+// symbols. The output only depends on the project data, so the same input
+// always produces byte-identical output.
+func renderClassDiagram(projectData *parser.RawProjectData) string {
+	classes := collectClasses(projectData)
+
+	// Index classes by package directory for reference resolution. Package
+	// names are not unique, so qualified references go through the imports
+	// of the referring file.
+	byPkgKey := make(map[string]map[string]*classInfo)
+	for _, class := range classes {
+		if byPkgKey[class.pkgKey] == nil {
+			byPkgKey[class.pkgKey] = make(map[string]*classInfo)
+		}
+		byPkgKey[class.pkgKey][class.decl.Name] = class
+	}
+
+	resolve := func(from *classInfo, ref string) *classInfo {
+		qualifier, name, qualified := strings.Cut(ref, ".")
+		if !qualified {
+			return byPkgKey[from.pkgKey][ref]
+		}
+		pkg := projectData.Packages[from.imports[qualifier]]
+		if pkg == nil {
+			return nil
+		}
+		return byPkgKey[pkg.Dir][name]
+	}
+
+	var lines []string
+	lines = append(lines, "classDiagram")
+
+	// Group classes by package with namespaces when more than one package is present
+	namespaces := namespaceNames(classes)
+	var currentPkg string
+	inNamespace := false
+	for _, class := range classes {
+		indent := "    "
+		if len(namespaces) > 1 {
+			if class.pkgKey != currentPkg {
+				if inNamespace {
+					lines = append(lines, "    }")
+				}
+				lines = append(lines, fmt.Sprintf("    namespace %s {", namespaces[class.pkgKey]))
+				currentPkg = class.pkgKey
+				inNamespace = true
+			}
+			indent = "        "
+		}
+		lines = append(lines, renderClass(class, indent, resolve)...)
+	}
+	if inNamespace {
+		lines = append(lines, "    }")
+	}
+
+	for _, edge := range collectClassEdges(projectData, classes, resolve) {
+		line := fmt.Sprintf("    %s %s %s", edge.left, edge.arrow, edge.right)
+		if len(edge.labels) > 0 {
+			line += " : " + strings.Join(edge.labels, ", ")
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// collectClasses gathers all structs, interfaces and named types with
+// methods, sorted by package and name, and assigns each a unique Mermaid id
+func collectClasses(projectData *parser.RawProjectData) []*classInfo {
+	paths := make([]string, 0, len(projectData.Files))
+	for filePath := range projectData.Files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	// Methods are attached to their receiver type within the same package
+	methods := make(map[string][]parser.Func)
+	for _, filePath := range paths {
+		fileData := projectData.Files[filePath]
+		pkgKey := path.Dir(filePath)
+		for _, method := range fileData.Methods {
+			if method.Receiver == nil {
+				continue
+			}
+			key := pkgKey + "\x00" + method.Receiver.Type
+			methods[key] = append(methods[key], method)
+		}
+	}
+
+	var classes []*classInfo
+	for _, filePath := range paths {
+		fileData := projectData.Files[filePath]
+		pkgKey := path.Dir(filePath)
+		for _, decl := range fileData.Types {
+			typeMethods := methods[pkgKey+"\x00"+decl.Name]
+			switch decl.Kind {
+			case parser.TypeKindStruct, parser.TypeKindInterface:
+			case parser.TypeKindNamed:
+				if len(typeMethods) == 0 {
+					continue
+				}
+			default:
+				continue
+			}
+
+			sortedMethods := append([]parser.Func(nil), typeMethods...)
+			sort.SliceStable(sortedMethods, func(i, j int) bool {
+				return sortedMethods[i].Name < sortedMethods[j].Name
+			})
+
+			classes = append(classes, &classInfo{
+				pkgKey:  pkgKey,
+				pkgName: fileData.PackageName,
+				decl:    decl,
+				methods: sortedMethods,
+				imports: projectData.ImportNames(fileData),
+			})
+		}
+	}
+
+	sort.SliceStable(classes, func(i, j int) bool {
+		if classes[i].pkgKey != classes[j].pkgKey {
+			return classes[i].pkgKey < classes[j].pkgKey
+		}
+		return classes[i].decl.Name < classes[j].decl.Name
+	})
+
+	// Type names that appear in more than one package are qualified with the
+	// package name, or with the package directory when those packages also
+	// share their name, such as several main packages
+	nameCount := make(map[string]int)
+	pkgNames := make(map[string]map[string]bool)
+	for _, class := range classes {
+		nameCount[class.decl.Name]++
+		if pkgNames[class.decl.Name] == nil {
+			pkgNames[class.decl.Name] = make(map[string]bool)
+		}
+		pkgNames[class.decl.Name][class.pkgName] = true
+	}
+	for _, class := range classes {
+		class.id = class.decl.Name
+		switch {
+		case nameCount[class.decl.Name] == 1:
+		case len(pkgNames[class.decl.Name]) == nameCount[class.decl.Name] || class.pkgKey == ".":
+			class.id = mermaidID(class.pkgName + "_" + class.decl.Name)
+		default:
+			class.id = mermaidID(class.pkgKey + "_" + class.decl.Name)
+		}
+	}
+
+	return classes
+}
+
+// namespaceNames picks a readable namespace name for each package directory,
+// falling back to the full directory when package names collide
+func namespaceNames(classes []*classInfo) map[string]string {
+	names := make(map[string]string)
+	dirsByName := make(map[string]map[string]bool)
+	for _, class := range classes {
+		if dirsByName[class.pkgName] == nil {
+			dirsByName[class.pkgName] = make(map[string]bool)
+		}
+		dirsByName[class.pkgName][class.pkgKey] = true
+	}
+	for _, class := range classes {
+		name := class.pkgName
+		if len(dirsByName[class.pkgName]) > 1 && class.pkgKey != "." {
+			name = class.pkgKey
+		}
+		names[class.pkgKey] = mermaidID(name)
+	}
+	return names
+}
+
+func renderClass(class *classInfo, indent string, resolve func(*classInfo, string) *classInfo) []string {
+	lines := []string{fmt.Sprintf("%sclass %s {", indent, class.id)}
+
+	switch class.decl.Kind {
+	case parser.TypeKindInterface:
+		lines = append(lines, indent+"    <<interface>>")
+		for _, method := range class.decl.Methods {
+			lines = append(lines, indent+"    "+renderMethod(method))
+		}
+	case parser.TypeKindNamed:
+		lines = append(lines, fmt.Sprintf("%s    <<%s>>", indent, sanitizeMemberType(class.decl.Underlying)))
+	case parser.TypeKindStruct:
+		for _, field := range class.decl.Fields {
+			// Embedded project types are rendered as edges instead
+			if field.Embedded && resolve(class, strings.TrimPrefix(field.Type, "*")) != nil {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s    %s%s %s", indent, visibility(field.Name), field.Name, sanitizeMemberType(field.Type)))
+		}
+	}
+
+	for _, method := range class.methods {
+		lines = append(lines, indent+"    "+renderMethod(method))
+	}
+
+	return append(lines, indent+"}")
+}
+
+// renderMethod formats a method as "+Name(paramTypes) resultTypes"
+func renderMethod(method parser.Func) string {
+	params := make([]string, 0, len(method.Params))
+	for _, param := range method.Params {
+		params = append(params, sanitizeMemberType(param.Type))
+	}
+	results := make([]string, 0, len(method.Results))
+	for _, result := range method.Results {
+		results = append(results, sanitizeMemberType(result.Type))
+	}
+
+	member := fmt.Sprintf("%s%s(%s)", visibility(method.Name), method.Name, strings.Join(params, ", "))
+	if len(results) > 0 {
+		member += " " + strings.Join(results, ", ")
+	}
+	return member
+}
+
+// collectClassEdges derives embedding, composition, aggregation and
+// implements relationships between the classes
+func collectClassEdges(projectData *parser.RawProjectData, classes []*classInfo, resolve func(*classInfo, string) *classInfo) []classEdge {
+	var edges []classEdge
+	index := make(map[string]int)
+	addEdge := func(left, arrow, right, label string) {
+		key := left + " " + arrow + " " + right
+		if i, ok := index[key]; ok {
+			if label != "" {
+				edges[i].labels = append(edges[i].labels, label)
+			}
+			return
+		}
+		edge := classEdge{left: left, arrow: arrow, right: right}
+		if label != "" {
+			edge.labels = []string{label}
+		}
+		index[key] = len(edges)
+		edges = append(edges, edge)
+	}
+
+	for _, class := range classes {
+		switch class.decl.Kind {
+		case parser.TypeKindStruct:
+			for _, field := range class.decl.Fields {
+				if field.Embedded {
+					if target := resolve(class, strings.TrimPrefix(field.Type, "*")); target != nil && target != class {
+						addEdge(target.id, "<|--", class.id, "")
+					}
+					continue
+				}
+
+				arrow := "*--"
+				if isReferenceType(field.Type) {
+					arrow = "o--"
+				}
+				for _, ref := range typeReferences(field.Type) {
+					if target := resolve(class, ref); target != nil && target != class {
+						addEdge(class.id, arrow, target.id, field.Name)
+					}
+				}
+			}
+		case parser.TypeKindInterface:
+			for _, embed := range class.decl.Embeds {
+				if target := resolve(class, embed); target != nil && target != class {
+					addEdge(target.id, "<|--", class.id, "")
+				}
+			}
+		}
+	}
+
+	// Implements edges come from the method sets of the parsed types
+	byPkgKey := make(map[string]*classInfo)
+	for _, class := range classes {
+		byPkgKey[class.pkgKey+"\x00"+class.decl.Name] = class
+	}
+	lookup := func(pkgPath, name string) *classInfo {
+		pkg := projectData.Packages[pkgPath]
+		if pkg == nil {
+			return nil
+		}
+		return byPkgKey[pkg.Dir+"\x00"+name]
+	}
+	for _, impl := range projectData.Implementations() {
+		class := lookup(impl.TypePackage, impl.TypeName)
+		iface := lookup(impl.InterfacePackage, impl.InterfaceName)
+		if class != nil && iface != nil {
+			addEdge(class.id, "..|>", iface.id, "")
+		}
+	}
+
+	return edges
+}
+
+// typeReferences returns the named types referenced by a type expression,
+// e.g. "map[string][]*pkg.Item" -> ["pkg.Item"]
+func typeReferences(typeText string) []string {
+	var refs []string
+	for _, ident := range typeIdentPattern.FindAllString(typeText, -1) {
+		switch ident {
+		case "map", "chan", "func", "struct", "interface":
+			continue
+		}
+		refs = append(refs, ident)
+	}
+	return refs
+}
+
+// isReferenceType reports whether a field refers to its target indirectly
+// (pointer, slice, map or channel) rather than containing it by value
+func isReferenceType(typeText string) bool {
+	for _, prefix := range []string{"*", "[]", "map[", "chan ", "<-chan ", "chan<- "} {
+		if strings.HasPrefix(typeText, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeMemberType simplifies a Go type expression into something Mermaid's
+// class diagram parser accepts: no braces, no parentheses and generics as ~T~
+func sanitizeMemberType(typeText string) string {
+	typeText = strings.Join(strings.Fields(typeText), " ")
+	typeText = strings.ReplaceAll(typeText, "interface{}", "any")
+	typeText = strings.ReplaceAll(typeText, "struct{}", "struct")
+
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(typeText); i++ {
+		c := typeText[i]
+		switch {
+		case c == '{' || c == '(':
+			depth++
+		case c == '}' || c == ')':
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case c == '[' && i > 0 && isIdentByte(typeText[i-1]) && !strings.HasSuffix(typeText[:i], "map"):
+			b.WriteByte('~')
+		case c == ']' && isGenericClose(typeText[:i]):
+			b.WriteByte('~')
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// isGenericClose reports whether the ']' following prefix closes a type argument list
+func isGenericClose(prefix string) bool {
+	depth := 0
+	for i := len(prefix) - 1; i >= 0; i-- {
+		switch prefix[i] {
+		case ']':
+			depth++
+		case '[':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			return i > 0 && isIdentByte(prefix[i-1]) && !strings.HasSuffix(prefix[:i], "map")
+		}
+	}
+	return false
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// visibility returns the Mermaid visibility marker for a Go identifier
+func visibility(name string) string {
+	if name != "" && name[0] >= 'A' && name[0] <= 'Z' {
+		return "+"
+	}
+	return "-"
+}
+
+// mermaidID replaces characters that are not valid in Mermaid identifiers
+func mermaidID(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if isIdentByte(name[i]) {
+			b.WriteByte(name[i])
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNoProvider is reported for diagrams that need the AI service when
+	// Options.Provider is nil and Deterministic is not set
+	ErrNoProvider = errors.New("no AI provider configured")
+
+	// ErrEmptyResponse is reported when the provider answers without text
+	ErrEmptyResponse = errors.New("no text content found in API response")
+
+	// ErrNoMermaidCode is reported when a response contains no diagram
+	ErrNoMermaidCode = errors.New("no Mermaid code found in API response")
+)
+
+// InvalidDiagramError reports a generated diagram that still failed
+// validation after every repair attempt
+type InvalidDiagramError struct {
+	Errors []MermaidError
+}
+
+func (e *InvalidDiagramError) Error() string {
+	return fmt.Sprintf("generated diagram has %d syntax errors after %d repairs, first %v", len(e.Errors), maxRepairAttempts, e.Errors[0])
+}
+
+// SummaryError reports package summaries the provider failed to produce or
+// condense for a project larger than the prompt budget. The affected
+// packages are described by their parsed symbols instead.
+type SummaryError struct {
+	Errs []error
+}
+
+func (e *SummaryError) Error() string {
+	return fmt.Sprintf("%d package summaries fell back to parsed symbols, first %v", len(e.Errs), e.Errs[0])
+}
+
+func (e *SummaryError) Unwrap() []error {
+	return e.Errs
+}
+
+// FallbackPolicy decides what replaces a diagram that could not be generated
+type FallbackPolicy string
+
+const (
+	FallbackNone          FallbackPolicy = "none"          // Leave the diagram out
+	FallbackPlaceholder   FallbackPolicy = "placeholder"   // Write a placeholder marking the diagram as unavailable
+	FallbackDeterministic FallbackPolicy = "deterministic" // Render the diagram from the parsed symbols
+
+	// FallbackSymbols is not a policy to choose. It marks AI diagrams drawn
+	// from package summaries of which some were replaced by the parsed
+	// symbols, see SummaryError.
+	FallbackSymbols FallbackPolicy = "symbols"
+)
+
+// ParseFallbackPolicy validates a FallbackPolicy value, defaulting to none
+func ParseFallbackPolicy(value string) (FallbackPolicy, error) {
+	switch FallbackPolicy(value) {
+	case "":
+		return FallbackNone, nil
+	case FallbackNone, FallbackPlaceholder, FallbackDeterministic:
+		return FallbackPolicy(value), nil
+	}
+	return "", fmt.Errorf("invalid fallback policy %q (expected none, placeholder or deterministic)", value)
+}
+
+// DiagramError reports a diagram that could not be generated as requested
+type DiagramError struct {
+	Kind DiagramKind
+	Err  error // Why generation failed
+
+	// Fallback is the substitute returned in place of the diagram, or
+	// FallbackNone if the diagram is missing from the results
+	Fallback FallbackPolicy
+}
+
+func (e *DiagramError) Error() string {
+	if e.Fallback == FallbackNone {
+		return fmt.Sprintf("%s diagram failed: %v", e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s diagram degraded to %s: %v", e.Kind, e.Fallback, e.Err)
+}
+
+func (e *DiagramError) Unwrap() error {
+	return e.Err
+}
+
+// GenerateError lists the diagrams of a GenerateDiagrams call that failed or
+// were replaced by a fallback, sorted by kind. The other diagrams are
+// returned alongside it.
+type GenerateError struct {
+	Diagrams []*DiagramError
+}
+
+func (e *GenerateError) Error() string {
+	messages := make([]string, len(e.Diagrams))
+	for i, diagramErr := range e.Diagrams {
+		messages[i] = diagramErr.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *GenerateError) Unwrap() []error {
+	errs := make([]error, len(e.Diagrams))
+	for i, diagramErr := range e.Diagrams {
+		errs[i] = diagramErr
+	}
+	return errs
+}
+
+// Failed reports whether any diagram is missing from the results, as
+// opposed to only replaced by a fallback
+func (e *GenerateError) Failed() bool {
+	for _, diagramErr := range e.Diagrams {
+		if diagramErr.Fallback == FallbackNone {
+			return true
+		}
+	}
+	return false
+}
+
+func newGenerateError(diagramErrs []*DiagramError) error {
+	if len(diagramErrs) == 0 {
+		return nil
+	}
+	sort.Slice(diagramErrs, func(i, j int) bool { return diagramErrs[i].Kind < diagramErrs[j].Kind })
+	return &GenerateError{Diagrams: diagramErrs}
+}
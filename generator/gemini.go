@@ -0,0 +1,103 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultGeminiModel = "gemini-1.5-pro"
+
+// geminiProvider talks to Google's Gemini API through the official SDK
+type geminiProvider struct {
+	apiKey  string
+	model   string
+	baseURL string
+}
+
+func newGeminiProvider(cfg ProviderConfig) (*geminiProvider, error) {
+	apiKey := cfg.APIKey
+	if apiKey == "" {
+		apiKey = firstEnv("GEMINI_API_KEY", "GOOGLE_API_KEY")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("gemini: %w (set GEMINI_API_KEY or GOOGLE_API_KEY)", ErrMissingAPIKey)
+	}
+
+	provider := &geminiProvider{
+		apiKey:  apiKey,
+		model:   cfg.Model,
+		baseURL: cfg.BaseURL,
+	}
+	if provider.model == "" {
+		provider.model = defaultGeminiModel
+	}
+	return provider, nil
+}
+
+func (p *geminiProvider) Name() string  { return "gemini" }
+func (p *geminiProvider) Model() string { return p.model }
+
+func (p *geminiProvider) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	opts := []option.ClientOption{option.WithAPIKey(p.apiKey)}
+	if p.baseURL != "" {
+		opts = append(opts, option.WithEndpoint(p.baseURL))
+	}
+
+	client, err := genai.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Gemini client: %w", err)
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel(p.model)
+	model.SystemInstruction = genai.NewUserContent(genai.Text(req.System))
+	if req.MaxTokens > 0 {
+		model.SetMaxOutputTokens(int32(req.MaxTokens))
+	}
+
+	resp, err := model.GenerateContent(ctx, genai.Text(req.Prompt))
+	if err != nil {
+		return nil, geminiError(err)
+	}
+
+	var text strings.Builder
+	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if t, ok := part.(genai.Text); ok {
+				text.WriteString(string(t))
+			}
+		}
+		break // Only the first candidate is used
+	}
+
+	return &CompletionResponse{Text: text.String()}, nil
+}
+
+// geminiError surfaces rate limits and outages as APIError, so they are
+// backed off and retried like those of other providers. The SDK reports
+// HTTP failures as googleapi errors and gRPC failures as status errors.
+func geminiError(err error) error {
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return &APIError{StatusCode: googleErr.Code, Body: googleErr.Message, Header: googleErr.Header}
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted:
+		return &APIError{StatusCode: http.StatusTooManyRequests, Body: status.Convert(err).Message()}
+	case codes.Unavailable:
+		return &APIError{StatusCode: http.StatusServiceUnavailable, Body: status.Convert(err).Message()}
+	}
+	return fmt.Errorf("API request error: %w", err)
+}
@@ -1,248 +1,356 @@
 package generator
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Nurozen/mermgen/parser"
+	"golang.org/x/sync/errgroup"
 )
 
-// Global rate limiter for API calls
 var (
-	lastAPICall         time.Time
-	minTimeBetweenCalls = 3 * time.Second // Minimum time between API calls to avoid rate limiting
-	maxRetries          = 3
+	maxRetries        = 3
+	maxRepairAttempts = 2 // Re-prompts with validation errors before falling back
 )
 
-// GenerateDiagrams generates various Mermaid diagrams from the parsed project data
-func GenerateDiagrams(projectData *parser.RawProjectData) (map[string]string, error) {
-	diagrams := make(map[string]string)
+// Options controls how diagrams are generated
+type Options struct {
+	// Provider is the AI backend used to generate diagrams. When nil, the
+	// diagrams that need it fail with ErrNoProvider unless Deterministic is set.
+	Provider Provider
+
+	// Deterministic renders diagrams that have a pure-Go renderer directly
+	// from the parsed symbols instead of calling the AI service
+	Deterministic bool
+
+	// ExternalDeps controls how third-party and standard library imports
+	// appear in the package diagram
+	ExternalDeps ExternalDeps
+
+	// SequenceEntry is the function the sequence diagram starts from, e.g.
+	// "main.main" or "api.Handler.ServeHTTP". Setting it renders the sequence
+	// diagram from the static call graph.
+	SequenceEntry string
+
+	// SequenceDepth limits how many levels of calls the sequence diagram follows
+	SequenceDepth int
+
+	// Budget limits the size of prompts and responses. Projects larger than
+	// one prompt are summarized package by package before the class and
+	// sequence diagrams are generated from the summaries.
+	Budget TokenBudget
+
+	// Cache answers repeated requests from disk and stores the responses
+	// that turned out usable, so invalid diagrams are never replayed. When
+	// nil, every request goes to the provider.
+	Cache *ResponseCache
+
+	// Limiter paces the API calls of all diagrams, which are generated
+	// concurrently. When nil, GenerateDiagrams allows one call every
+	// DefaultRequestInterval.
+	Limiter *RateLimiter
+
+	// Fallback decides what replaces a diagram that cannot be generated,
+	// for example because the AI service failed or returned an invalid
+	// diagram. The zero value leaves the diagram out.
+	Fallback FallbackPolicy
+
+	// Logger receives progress messages and the reasons for degraded
+	// diagrams. When nil, slog's default logger is used.
+	Logger *slog.Logger
+
+	summaries *summaryStore // Shared by the diagrams of one GenerateDiagrams call
+}
 
-	// Generate different types of diagrams
-	classDiagram, err := generateClassDiagram(projectData)
-	if err != nil {
-		return nil, fmt.Errorf("error generating class diagram: %w", err)
+// logger returns the configured logger or slog's default logger
+func (opts Options) logger() *slog.Logger {
+	if opts.Logger == nil {
+		return slog.Default()
 	}
-	diagrams["class-diagram"] = classDiagram
+	return opts.Logger
+}
 
-	packageDiagram, err := generatePackageDiagram(projectData)
-	if err != nil {
-		return nil, fmt.Errorf("error generating package diagram: %w", err)
-	}
-	diagrams["package-diagram"] = packageDiagram
+// DiagramKind identifies one of the diagrams mermgen can generate
+type DiagramKind string
 
-	sequenceDiagram, err := generateSequenceDiagram(projectData)
-	if err != nil {
-		return nil, fmt.Errorf("error generating sequence diagram: %w", err)
-	}
-	diagrams["sequence-diagram"] = sequenceDiagram
+const (
+	DiagramClass    DiagramKind = "class"
+	DiagramPackage  DiagramKind = "package"
+	DiagramSequence DiagramKind = "sequence"
+	DiagramModules  DiagramKind = "modules" // Overview of a multi-module repository, only generated on request
+)
 
-	return diagrams, nil
+// diagramGenerator produces the Markdown document of a single diagram kind.
+// A *SummaryError returned along with a document marks it as degraded.
+type diagramGenerator func(ctx context.Context, projectData *parser.RawProjectData, opts Options) (string, error)
+
+// diagramGenerators is the registry of supported diagram kinds
+var diagramGenerators = map[DiagramKind]diagramGenerator{
+	DiagramClass:    generateClassDiagram,
+	DiagramPackage:  generatePackageDiagram,
+	DiagramSequence: generateSequenceDiagram,
+	DiagramModules:  generateModuleDiagram,
 }
 
-// generateClassDiagram creates a Mermaid class diagram from project data
-func generateClassDiagram(projectData *parser.RawProjectData) (string, error) {
-	// Prepare data for the AI prompt
-	fileInfo := make([]map[string]interface{}, 0)
-
-	// Counter to limit amount of data we send to the API
-	const maxFiles = 10
-	const maxContentLength = 1000000
-	fileCount := 0
-
-	for path, fileData := range projectData.Files {
-		// Only process Go files and limit number of files
-		if !strings.HasSuffix(path, ".go") || fileCount >= maxFiles {
+// DiagramKinds returns the diagram kinds generated by default, in generation order
+func DiagramKinds() []DiagramKind {
+	return []DiagramKind{DiagramClass, DiagramPackage, DiagramSequence}
+}
+
+// ParseDiagramKinds parses a comma separated list of diagram kinds such as
+// "class,sequence". An empty list or "all" selects the default kinds.
+func ParseDiagramKinds(value string) ([]DiagramKind, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "all" {
+		return DiagramKinds(), nil
+	}
+
+	var kinds []DiagramKind
+	seen := make(map[DiagramKind]bool)
+	for _, name := range strings.Split(value, ",") {
+		kind := DiagramKind(strings.ToLower(strings.TrimSpace(name)))
+		if kind == "" || seen[kind] {
 			continue
 		}
-
-		// Truncate content if too large
-		content := fileData.Content
-		if len(content) > maxContentLength {
-			content = content[:maxContentLength] + "... [truncated]"
+		if _, ok := diagramGenerators[kind]; !ok {
+			return nil, fmt.Errorf("unknown diagram type %q (expected %s)", kind, joinKinds(append(DiagramKinds(), DiagramModules)))
 		}
+		seen[kind] = true
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+func joinKinds(kinds []DiagramKind) string {
+	names := make([]string, len(kinds))
+	for i, kind := range kinds {
+		names[i] = string(kind)
+	}
+	return strings.Join(names, ", ")
+}
 
-		// For parse tree, only include a summary to reduce size
-		parseTreeSummary := "Parse tree available (truncated for size)"
-		if len(fileData.ParseTree) < 100 {
-			parseTreeSummary = fileData.ParseTree
+// GenerateDiagrams generates the requested Mermaid diagrams from the parsed
+// project data, keyed by output name such as "class-diagram". An empty list
+// of kinds generates every diagram. Cancelling ctx stops all pending AI
+// calls and returns ctx's error.
+//
+// A diagram that cannot be generated does not stop the others. It is
+// replaced according to opts.Fallback, and the diagrams are returned
+// together with a *GenerateError listing every failed or replaced diagram.
+// Diagrams drawn from package summaries of which some fell back to parsed
+// symbols are listed too, with FallbackSymbols.
+func GenerateDiagrams(ctx context.Context, projectData *parser.RawProjectData, kinds []DiagramKind, opts Options) (map[string]string, error) {
+	if len(kinds) == 0 {
+		kinds = DiagramKinds()
+	}
+
+	// Validate the whole selection before paying for any AI calls
+	for _, kind := range kinds {
+		if _, ok := diagramGenerators[kind]; !ok {
+			return nil, fmt.Errorf("unknown diagram type %q (expected %s)", kind, joinKinds(append(DiagramKinds(), DiagramModules)))
 		}
+	}
+
+	if opts.summaries == nil {
+		opts.summaries = &summaryStore{}
+	}
+	if opts.Limiter == nil {
+		opts.Limiter = NewRateLimiter(DefaultRequestInterval, 1)
+		opts.Limiter.Logger = opts.Logger
+	}
+
+	// The diagrams are independent, so they are generated concurrently and
+	// only share the rate limiter and the package summaries. Only
+	// cancellation ends the group early.
+	var mu sync.Mutex
+	diagrams := make(map[string]string)
+	var diagramErrs []*DiagramError
+	group, ctx := errgroup.WithContext(ctx)
+	for _, kind := range kinds {
+		group.Go(func() error {
+			opts.logger().Debug("Generating diagram", "kind", kind)
+			diagram, err := diagramGenerators[kind](ctx, projectData, opts)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			var diagramErr *DiagramError
+			var summaryErr *SummaryError
+			switch {
+			case err == nil:
+			case diagram != "" && errors.As(err, &summaryErr):
+				// Drawn from summaries that were partly replaced by symbols
+				diagramErr = &DiagramError{Kind: kind, Err: err, Fallback: FallbackSymbols}
+				opts.logger().Warn("Diagram degraded", "kind", kind, "fallback", diagramErr.Fallback, "error", err)
+			default:
+				diagram, diagramErr = fallbackDiagram(ctx, projectData, kind, err, opts)
+			}
 
-		fileInfo = append(fileInfo, map[string]interface{}{
-			"path":        path,
-			"packageName": fileData.PackageName,
-			"content":     content,
-			"parseTree":   parseTreeSummary,
+			mu.Lock()
+			defer mu.Unlock()
+			if diagramErr != nil {
+				diagramErrs = append(diagramErrs, diagramErr)
+			}
+			if diagram != "" {
+				diagrams[string(kind)+"-diagram"] = diagram
+			}
+			return nil
 		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	return diagrams, newGenerateError(diagramErrs)
+}
 
-		fileCount++
+// fallbackDiagram applies the fallback policy to a diagram that failed with
+// err. It returns the substitute, empty if there is none, and the error
+// describing what happened.
+func fallbackDiagram(ctx context.Context, projectData *parser.RawProjectData, kind DiagramKind, err error, opts Options) (string, *DiagramError) {
+	diagramErr := &DiagramError{Kind: kind, Err: err, Fallback: FallbackNone}
+	var diagram string
+	switch opts.Fallback {
+	case FallbackPlaceholder:
+		diagram = createFallbackDiagram(string(kind))
+		diagramErr.Fallback = FallbackPlaceholder
+	case FallbackDeterministic:
+		// Diagrams that failed to render deterministically in the first place
+		// fail again, so they are left out
+		if !opts.Deterministic {
+			opts.Deterministic = true
+			if rendered, renderErr := diagramGenerators[kind](ctx, projectData, opts); renderErr == nil {
+				diagram = rendered
+				diagramErr.Fallback = FallbackDeterministic
+			}
+		}
 	}
 
-	//limit fileinfo to maxcontentlength
-	jsonFile, err := json.Marshal(fileInfo)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling fileInfo: %w", err)
+	if diagramErr.Fallback == FallbackNone {
+		opts.logger().Error("Diagram failed", "kind", kind, "error", err)
+	} else {
+		opts.logger().Warn("Diagram degraded", "kind", kind, "fallback", diagramErr.Fallback, "error", err)
 	}
+	return diagram, diagramErr
+}
 
-	jsonFileString := string(jsonFile)
+// generateClassDiagram creates a Mermaid class diagram from project data
+func generateClassDiagram(ctx context.Context, projectData *parser.RawProjectData, opts Options) (string, error) {
+	if opts.Deterministic {
+		return formatDiagram("class", renderClassDiagram(projectData)), nil
+	}
+	if opts.Provider == nil {
+		return "", ErrNoProvider
+	}
 
-	//limit jsonfile to maxcontentlength
-	if len(jsonFileString) > maxContentLength {
-		jsonFileString = jsonFileString[:maxContentLength] + "... [truncated]"
+	// Include the extracted symbols so the model works from declarations
+	// rather than having to recover them from the source text
+	key, projectInfo, degraded, err := projectContext(ctx, projectData, opts, true)
+	if err != nil {
+		return "", err
 	}
 
 	// Create AI prompt with clear instructions
 	prompt := map[string]interface{}{
 		"task":        "Generate a Mermaid class diagram that shows the structure and relationships between types in the Go codebase",
-		"fileInfo":    jsonFileString,
-		"explanation": "Create a class diagram showing the main types, their fields, methods, and relationships. Group related types together and focus on important relationships.",
+		key:           projectInfo,
+		"implements":  implementsList(projectData),
+		"explanation": "Create a class diagram showing the main types, their fields, methods, and relationships. Group related types together and focus on important relationships. Draw each implements pair as a realization edge (..|>).",
 	}
 
 	// Call AI to generate diagram
-	return callAI(prompt, "class")
+	diagram, err := callAI(ctx, opts, prompt, "class")
+	if err != nil {
+		return "", err
+	}
+	return diagram, degraded
 }
 
-// generatePackageDiagram creates a Mermaid package diagram from project data
-func generatePackageDiagram(projectData *parser.RawProjectData) (string, error) {
-	// Prepare data for the AI prompt
-	fileInfo := make([]map[string]interface{}, 0)
-
-	// Counter to limit amount of data we send to the API
-	const maxFiles = 10
-	const maxContentLength = 100000
-	fileCount := 0
-
-	for path, fileData := range projectData.Files {
-		// Only process Go files and limit number of files
-		if !strings.HasSuffix(path, ".go") || fileCount >= maxFiles {
-			continue
-		}
-
-		// For package diagrams, we only need imports section
-		// Find imports section to reduce content size
-		content := extractImportsSection(fileData.Content)
-		if len(content) > maxContentLength {
-			content = content[:maxContentLength] + "... [truncated]"
+// implementsList describes the interface implementations found by method
+// set analysis, which the model cannot reliably infer from truncated sources
+func implementsList(projectData *parser.RawProjectData) []string {
+	var implements []string
+	for _, impl := range projectData.Implementations() {
+		typeName := impl.TypePackage + "." + impl.TypeName
+		if impl.PointerOnly {
+			typeName = "*" + typeName
 		}
+		implements = append(implements, typeName+" implements "+impl.InterfacePackage+"."+impl.InterfaceName)
+	}
+	return implements
+}
 
-		fileInfo = append(fileInfo, map[string]interface{}{
-			"path":        path,
-			"packageName": fileData.PackageName,
-			"content":     content,
-		})
-
-		fileCount++
+// generatePackageDiagram creates a Mermaid package diagram from project data
+func generatePackageDiagram(ctx context.Context, projectData *parser.RawProjectData, opts Options) (string, error) {
+	if opts.Deterministic {
+		return formatDiagram("package", renderPackageDiagram(projectData, opts.ExternalDeps)), nil
+	}
+	if opts.Provider == nil {
+		return "", ErrNoProvider
 	}
 
+	// The resolved graph covers every package and is much smaller than the sources
+	graph := buildPackageGraph(projectData, opts.ExternalDeps)
+
 	// Create AI prompt with clear instructions
 	prompt := map[string]interface{}{
 		"task":        "Generate a Mermaid package diagram showing the structure and dependencies between packages in the Go codebase",
-		"fileInfo":    fileInfo,
-		"explanation": "Create a package diagram showing how packages depend on each other. Group related packages together and show the main dependencies between them.",
+		"packages":    graph.Nodes,
+		"imports":     graph.Edges,
+		"explanation": "Create a package diagram showing how packages depend on each other. Each import pair lists the importing package first. Group related packages together and show the main dependencies between them.",
 	}
 
 	// Call AI to generate diagram
-	return callAI(prompt, "package")
+	return callAI(ctx, opts, prompt, "package")
 }
 
-// generateSequenceDiagram creates a sample sequence diagram
-func generateSequenceDiagram(projectData *parser.RawProjectData) (string, error) {
-	// Prepare data for the AI prompt
-	fileInfo := make([]map[string]interface{}, 0)
-
-	// Counter to limit amount of data we send to the API
-	const maxFiles = 10
-	const maxContentLength = 100000
-	fileCount := 0
-
-	for path, fileData := range projectData.Files {
-		// Only process Go files and limit number of files
-		if !strings.HasSuffix(path, ".go") || fileCount >= maxFiles {
-			continue
-		}
-
-		// Truncate content if too large
-		content := fileData.Content
-		if len(content) > maxContentLength {
-			content = content[:maxContentLength] + "... [truncated]"
+// generateSequenceDiagram creates a sequence diagram, either from the static
+// call graph or by asking the AI service for the key interactions
+func generateSequenceDiagram(ctx context.Context, projectData *parser.RawProjectData, opts Options) (string, error) {
+	if opts.SequenceEntry != "" || opts.Deterministic {
+		mermaidCode, err := renderSequenceDiagram(projectData, opts.SequenceEntry, opts.SequenceDepth, opts.ExternalDeps)
+		if err != nil {
+			return "", err
 		}
+		return formatDiagram("sequence", mermaidCode), nil
+	}
+	if opts.Provider == nil {
+		return "", ErrNoProvider
+	}
 
-		fileInfo = append(fileInfo, map[string]interface{}{
-			"path":        path,
-			"packageName": fileData.PackageName,
-			"content":     content,
-		})
-
-		fileCount++
+	key, projectInfo, degraded, err := projectContext(ctx, projectData, opts, false)
+	if err != nil {
+		return "", err
 	}
 
 	// Create AI prompt with clear instructions
 	prompt := map[string]interface{}{
 		"task":        "Generate a Mermaid sequence diagram that shows the flow of execution between key functions",
-		"fileInfo":    fileInfo,
+		key:           projectInfo,
 		"explanation": "Create a sequence diagram showing how the main components interact with each other. Focus on the most important function calls between different packages and types.",
 	}
 
 	// Call AI to generate diagram
-	return callAI(prompt, "sequence")
-}
-
-// extractImportsSection extracts just the package and imports section from Go code
-func extractImportsSection(content string) string {
-	lines := strings.Split(content, "\n")
-	var result []string
-	inImportBlock := false
-
-	for _, line := range lines {
-		trimmedLine := strings.TrimSpace(line)
-
-		// Always include package declaration
-		if strings.HasPrefix(trimmedLine, "package ") {
-			result = append(result, line)
-			continue
-		}
-
-		// Track import blocks
-		if strings.HasPrefix(trimmedLine, "import (") {
-			inImportBlock = true
-			result = append(result, line)
-			continue
-		}
-
-		if inImportBlock {
-			result = append(result, line)
-			if trimmedLine == ")" {
-				inImportBlock = false
-				break // Stop after import block
-			}
-		} else if strings.HasPrefix(trimmedLine, "import ") {
-			result = append(result, line)
-		}
+	diagram, err := callAI(ctx, opts, prompt, "sequence")
+	if err != nil {
+		return "", err
 	}
-
-	return strings.Join(result, "\n")
+	return diagram, degraded
 }
 
-// callAI calls Anthropic's Claude AI service to generate a Mermaid diagram
-func callAI(prompt map[string]interface{}, diagramType string) (string, error) {
-	// Get API key from environment
-	apiKey := os.Getenv("ANTHROPIC_API_KEY")
-	if apiKey == "" {
-		fmt.Println("ANTHROPIC_API_KEY not set, using fallback diagram")
-		return createFallbackDiagram(diagramType), nil
-	}
-
+// callAI asks the configured AI provider to generate a Mermaid diagram,
+// feeding syntax errors back to the model for repairs
+func callAI(ctx context.Context, opts Options, prompt map[string]interface{}, diagramType string) (string, error) {
 	// Convert prompt to JSON
 	promptJSON, err := json.MarshalIndent(prompt, "", "  ")
 	if err != nil {
-		fmt.Printf("Error marshaling prompt: %v, using fallback diagram\n", err)
-		return createFallbackDiagram(diagramType), nil
+		return "", fmt.Errorf("error marshaling prompt: %w", err)
 	}
 
 	// Include detailed instructions based on diagram type
@@ -278,198 +386,141 @@ func callAI(prompt map[string]interface{}, diagramType string) (string, error) {
 		"Even if you think the data is incomplete, create the best diagram possible with what's provided. Prioritize human readability. Always double check the output for mermaid syntax errors.",
 		diagramType, string(promptJSON))
 
-	// Create request to Claude API
-	requestURL := "https://api.anthropic.com/v1/messages"
-
-	// Prepare Claude API request
-	requestBody := map[string]interface{}{
-		"model":       "claude-3-7-sonnet-20250219",
-		"max_tokens":  64000,
-		"temperature": 1,
-		"system":      systemPrompt,
-		"messages": []map[string]interface{}{
-			{
-				"role":    "user",
-				"content": promptStr,
-			},
-		},
-		"thinking": map[string]interface{}{
-			"type":          "enabled",
-			"budget_tokens": 16000,
-		},
-	}
-
-	requestJSON, err := json.Marshal(requestBody)
-	if err != nil {
-		fmt.Printf("Error creating request body: %v, using fallback diagram\n", err)
-		return createFallbackDiagram(diagramType), nil
+	request := CompletionRequest{
+		System:    systemPrompt,
+		Prompt:    promptStr,
+		MaxTokens: opts.Budget.DiagramTokens,
 	}
 
-	// Try API call with retries and rate limiting
-	var responseBody []byte
-	var apiError error
-
-	for retry := 0; retry < maxRetries; retry++ {
-		// Check if we need to wait before making another API call (rate limiting)
-		timeSinceLastCall := time.Since(lastAPICall)
-		if timeSinceLastCall < minTimeBetweenCalls {
-			waitTime := minTimeBetweenCalls - timeSinceLastCall
-			fmt.Printf("Rate limiting: waiting %v before next API call\n", waitTime)
-			time.Sleep(waitTime)
+	// Cached responses were valid when stored and need neither rate limiting
+	// nor retries. Entries that no longer validate are requested again.
+	original := request
+	if text, ok := opts.Cache.lookup(opts.Provider, request); ok {
+		if mermaidCode := extractMermaidCode(text); mermaidCode != "" && len(validateDiagram(diagramType, mermaidCode)) == 0 {
+			return formatDiagram(diagramType, mermaidCode), nil
 		}
+	}
 
-		// Update last API call time
-		lastAPICall = time.Now()
+	content, err := complete(ctx, opts, request)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", err
+	}
 
-		// Create HTTP request
-		req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(requestJSON))
-		if err != nil {
-			apiError = fmt.Errorf("error creating request: %w", err)
-			continue
+	// Validate the diagram and feed syntax errors back to the model until it
+	// produces a diagram that renders or runs out of repair attempts
+	for attempt := 0; ; attempt++ {
+		// Extract Mermaid code from content
+		mermaidCode := extractMermaidCode(content)
+		if mermaidCode == "" {
+			return "", ErrNoMermaidCode
 		}
 
-		// Set headers
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("x-api-key", apiKey)
-		req.Header.Set("anthropic-version", "2023-06-01")
-
-		// Send request
-		client := &http.Client{Timeout: 6000 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			apiError = fmt.Errorf("API request error: %w", err)
-			continue
+		syntaxErrors := validateDiagram(diagramType, mermaidCode)
+		if len(syntaxErrors) == 0 {
+			// The valid diagram answers the original request on the next run
+			opts.Cache.store(opts.Provider, original, content, opts.logger())
+			// Format the final output as a Markdown document with the Mermaid diagram
+			return formatDiagram(diagramType, mermaidCode), nil
 		}
-
-		// Read response
-		defer resp.Body.Close()
-		responseBody, err = io.ReadAll(resp.Body)
-		if err != nil {
-			apiError = fmt.Errorf("error reading response: %w", err)
-			continue
+		if attempt == maxRepairAttempts {
+			return "", &InvalidDiagramError{Errors: syntaxErrors}
 		}
 
-		// Check status code
-		if resp.StatusCode != http.StatusOK {
-			apiError = fmt.Errorf("API error status %d: %s", resp.StatusCode, string(responseBody))
-
-			// For rate limiting errors, wait longer and retry
-			if resp.StatusCode == 429 {
-				waitTime := time.Duration(4<<retry) * time.Second
-				fmt.Printf("Rate limit exceeded. Retrying in %v (retry %d/%d)...\n",
-					waitTime, retry+1, maxRetries)
-				time.Sleep(waitTime)
-				continue
-			}
+		opts.logger().Info("Generated diagram has syntax errors, asking for a repair",
+			"kind", diagramType, "errors", len(syntaxErrors), "attempt", attempt+1, "of", maxRepairAttempts)
+		request.Prompt = fmt.Sprintf("The following Mermaid %s diagram fails to render:\n\n```mermaid\n%s\n```\n\n"+
+			"The validator reported these errors (line numbers refer to the diagram code):\n%s\n\n"+
+			"Fix the errors while keeping the content of the diagram. Only return the corrected Mermaid diagram code, nothing else.",
+			diagramType, mermaidCode, formatMermaidErrors(syntaxErrors))
 
-			continue
+		content, err = complete(ctx, opts, request)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if err != nil {
+			return "", err
 		}
-
-		// If we got here, request was successful
-		apiError = nil
-		break
 	}
+}
 
-	// If we still have an error after all retries, use fallback
-	if apiError != nil {
-		fmt.Printf("All API retries failed: %v, using fallback diagram\n", apiError)
-		return createFallbackDiagram(diagramType), nil
-	}
+// complete sends a request to the provider with retries and rate limiting
+// and returns the text of the response
+func complete(ctx context.Context, opts Options, request CompletionRequest) (string, error) {
+	provider := opts.Provider
 
-	// Parse response
-	var response struct {
-		Content []struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
-		} `json:"content"`
-	}
+	var response *CompletionResponse
+	var apiError error
 
-	if err := json.Unmarshal(responseBody, &response); err != nil {
-		fmt.Printf("Error parsing response: %v, using fallback diagram\n", err)
-		return createFallbackDiagram(diagramType), nil
-	}
+	for retry := 0; retry < maxRetries; retry++ {
+		// Wait for the shared rate limiter before making another API call
+		if err := opts.Limiter.Wait(ctx); err != nil {
+			return "", err
+		}
 
-	// Extract text from response
-	var content string
-	for _, part := range response.Content {
-		if part.Type == "text" {
-			content = part.Text
+		response, apiError = provider.Complete(ctx, request)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if apiError == nil {
+			// Pause the other calls early when a limit is about to be exhausted
+			opts.Limiter.Update(response.Header)
 			break
 		}
-	}
 
-	if content == "" {
-		fmt.Println("No text content found in API response, using fallback diagram")
-		return createFallbackDiagram(diagramType), nil
+		// For rate limiting and unavailable services, wait as long as the
+		// provider asks, or back off exponentially, before the next attempt
+		var statusErr *APIError
+		if errors.As(apiError, &statusErr) && (statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode == http.StatusServiceUnavailable) {
+			opts.logger().Warn("Rate limit exceeded or service unavailable, retrying", "status", statusErr.StatusCode, "retry", retry+1, "of", maxRetries)
+			if !opts.Limiter.Update(statusErr.Header) {
+				opts.Limiter.Backoff(time.Duration(4<<retry) * time.Second)
+			}
+		}
 	}
 
-	// Extract Mermaid code from content
-	mermaidCode := extractMermaidCode(content)
-	if mermaidCode == "" {
-		fmt.Println("No Mermaid code found in API response, using fallback diagram")
-		return createFallbackDiagram(diagramType), nil
+	if apiError != nil {
+		return "", fmt.Errorf("all API retries failed: %w", apiError)
 	}
-
-	// Format the final output as a Markdown document with the Mermaid diagram
-	markdown := fmt.Sprintf("# %s Diagram\n\n```mermaid\n%s\n```\n",
-		strings.Title(diagramType),
-		mermaidCode)
-
-	return markdown, nil
+	if response.Text == "" {
+		return "", ErrEmptyResponse
+	}
+	return response.Text, nil
 }
 
-// createFallbackDiagram generates a simple default diagram when the AI service fails
+// createFallbackDiagram generates a placeholder for a diagram that could not
+// be generated. It is marked as such, so it cannot be mistaken for a diagram
+// of the project.
 func createFallbackDiagram(diagramType string) string {
 	var mermaidCode string
 
 	switch diagramType {
 	case "class":
 		mermaidCode = `classDiagram
-    class Parser {
-        +ParseGoProject(path) RawProjectData
-    }
-    class RawProjectData {
-        +Files map[string]*FileData
-    }
-    class FileData {
-        +Content string
-        +PackageName string
-        +ParseTree string
-    }
-    class Generator {
-        +GenerateDiagrams(data) map[string]string
-    }
-    RawProjectData o-- FileData
-    Parser ..> RawProjectData
-    Generator ..> RawProjectData`
-	case "package":
-		mermaidCode = `flowchart LR
-    main[main] --> parser[parser]
-    main --> generator[generator]
-    main --> github[github]
-    generator --> parser`
+    class Unavailable {
+        <<placeholder>>
+    }`
 	case "sequence":
 		mermaidCode = `sequenceDiagram
-    participant Main
-    participant Parser
-    participant Generator
-    
-    Main->>Parser: ParseGoProject(path)
-    Parser-->>Main: projectData
-    Main->>Generator: GenerateDiagrams(projectData)
-    Generator-->>Main: diagrams`
+    participant mermgen
+    Note over mermgen: Diagram unavailable`
 	default:
-		mermaidCode = `graph TD
-    A[Start] --> B[Process Data]
-    B --> C[Generate Output]`
+		mermaidCode = `flowchart LR
+    unavailable["Diagram unavailable"]`
 	}
 
-	// Format as markdown
-	markdown := fmt.Sprintf("# %s Diagram\n\n```mermaid\n%s\n```\n",
+	return fmt.Sprintf("# %s Diagram\n\n> **Placeholder:** mermgen could not generate this diagram. See the mermgen log for the reason.\n\n```mermaid\n%s\n```\n",
 		strings.Title(diagramType),
 		mermaidCode)
+}
 
-	return markdown
+// formatDiagram wraps Mermaid code in a Markdown document
+func formatDiagram(diagramType string, mermaidCode string) string {
+	return fmt.Sprintf("# %s Diagram\n\n```mermaid\n%s\n```\n",
+		strings.Title(diagramType),
+		mermaidCode)
 }
 
 // Helper function to get keys from a map
@@ -0,0 +1,1067 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Nurozen/mermgen/parser"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// parseTestProject writes the given files into a temporary directory and parses them
+func parseTestProject(t *testing.T, files map[string]string) *parser.RawProjectData {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "generator-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	projectData, err := parser.ParseGoProject(context.Background(), tmpDir, parser.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+	return projectData
+}
+
+var shapesProject = map[string]string{
+	"go.mod": "module example.com/app\n\ngo 1.22\n",
+	"shapes/shapes.go": `package shapes
+
+import "example.com/app/style"
+
+type Shape interface {
+	Area() float64
+}
+
+type Base struct {
+	ID string
+}
+
+type Square struct {
+	Base
+	Side   float64
+	Fill   style.Color
+	Border *style.Border
+	tags   map[string]func(int) error
+}
+
+func (s *Square) Area() float64 { return s.Side * s.Side }
+`,
+	"style/style.go": `package style
+
+type Color struct {
+	R, G, B uint8
+}
+
+type Border struct {
+	Width int
+	Color Color
+}
+`,
+}
+
+func TestRenderClassDiagram(t *testing.T) {
+	projectData := parseTestProject(t, shapesProject)
+	diagram := renderClassDiagram(projectData)
+
+	expected := []string{
+		"classDiagram",
+		"    namespace shapes {",
+		"        class Shape {",
+		"            <<interface>>",
+		"            +Area() float64",
+		"            +Side float64",
+		"            -tags map[string]func error",
+		"    namespace style {",
+		"    Base <|-- Square",
+		"    Square *-- Color : Fill",
+		"    Square o-- Border : Border",
+		"    Border *-- Color : Color",
+		"    Square ..|> Shape",
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(diagram, "\n") {
+		lines[line] = true
+	}
+	for _, line := range expected {
+		if !lines[line] {
+			t.Errorf("Expected diagram to contain line %q, got:\n%s", line, diagram)
+		}
+	}
+
+	// Embedded project types are edges, not fields
+	if strings.Contains(diagram, "+Base ") {
+		t.Errorf("Embedded field rendered as a member:\n%s", diagram)
+	}
+
+	// The same input must always produce the same output
+	for i := 0; i < 5; i++ {
+		if again := renderClassDiagram(parseTestProject(t, shapesProject)); again != diagram {
+			t.Fatalf("Class diagram is not deterministic:\n%s\n---\n%s", diagram, again)
+		}
+	}
+}
+
+func TestRenderClassDiagramDuplicateNames(t *testing.T) {
+	projectData := parseTestProject(t, map[string]string{
+		"go.mod":         "module example.com/tools\n\ngo 1.22\n",
+		"cmd/a/main.go":  "package main\n\ntype config struct{ A string }\n",
+		"cmd/b/main.go":  "package main\n\ntype config struct{ B string }\n",
+		"store/store.go": "package store\n\ntype config struct{ DSN string }\n",
+	})
+	diagram := renderClassDiagram(projectData)
+
+	// Packages sharing the name main are told apart by their directory
+	for _, id := range []string{"cmd_a_config", "cmd_b_config", "store_config"} {
+		if count := strings.Count(diagram, "class "+id+" {"); count != 1 {
+			t.Errorf("Expected class %s once, found %d times:\n%s", id, count, diagram)
+		}
+	}
+	if errs := ValidateMermaid(diagram); len(errs) != 0 {
+		t.Errorf("Expected a valid class diagram, got %v:\n%s", errs, diagram)
+	}
+	for _, namespace := range []string{"cmd_a", "cmd_b", "store"} {
+		if count := strings.Count(diagram, "namespace "+namespace+" {"); count != 1 {
+			t.Errorf("Expected namespace %s once, found %d times:\n%s", namespace, count, diagram)
+		}
+	}
+}
+
+func TestRenderClassDiagramResolvesImports(t *testing.T) {
+	projectData := parseTestProject(t, map[string]string{
+		"go.mod":         "module example.com/app\n\ngo 1.22\n",
+		"a/util/util.go": "package util\n\ntype Item struct{ A string }\n",
+		"b/util/util.go": "package util\n\ntype Item struct{ B string }\n",
+		"lib/v2/lib.go":  "package lib\n\ntype Client struct{}\n",
+		"svc/svc.go": "package svc\n\nimport (\n\t\"example.com/app/a/util\"\n\t\"example.com/app/lib/v2\"\n)\n\n" +
+			"type Svc struct {\n\tIt util.Item\n\tClient *lib.Client\n}\n",
+	})
+	diagram := renderClassDiagram(projectData)
+
+	// Only the imported util package is referenced, and the major version
+	// suffix of lib/v2 does not hide its package name
+	for _, line := range []string{"    Svc *-- a_util_Item : It", "    Svc o-- Client : Client"} {
+		if !strings.Contains(diagram+"\n", line+"\n") {
+			t.Errorf("Expected diagram to contain %q, got:\n%s", line, diagram)
+		}
+	}
+	if strings.Contains(diagram, "Svc *-- b_util_Item") {
+		t.Errorf("Expected no edge to the package that is not imported:\n%s", diagram)
+	}
+
+	// Namespaces of packages sharing a name are unique
+	for _, namespace := range []string{"a_util", "b_util", "lib", "svc"} {
+		if count := strings.Count(diagram, "namespace "+namespace+" {"); count != 1 {
+			t.Errorf("Expected namespace %s once, found %d times:\n%s", namespace, count, diagram)
+		}
+	}
+	if errs := ValidateMermaid(diagram); len(errs) != 0 {
+		t.Errorf("Expected a valid class diagram, got %v:\n%s", errs, diagram)
+	}
+}
+
+func TestSanitizeMemberType(t *testing.T) {
+	tests := map[string]string{
+		"[]string":                   "[]string",
+		"map[string][]*Item":         "map[string][]*Item",
+		"List[T]":                    "List~T~",
+		"*Cache[string, []Entry[K]]": "*Cache~string, []Entry~K~~",
+		"func(a int) (bool, error)":  "func",
+		"interface{}":                "any",
+		"struct {\n\tX int\n}":       "struct",
+		"chan<- error":               "chan<- error",
+	}
+	for input, want := range tests {
+		if got := sanitizeMemberType(input); got != want {
+			t.Errorf("sanitizeMemberType(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+var layeredProject = map[string]string{
+	"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire github.com/spf13/cobra v1.8.0\n",
+	"main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/app/api"
+	"github.com/spf13/cobra"
+)
+
+func main() { fmt.Println(api.New(), cobra.Command{}) }
+`,
+	"api/api.go": `package api
+
+import (
+	"net/http"
+
+	"example.com/app/store"
+	"github.com/spf13/cobra/doc"
+)
+`,
+	"store/store.go": "package store\n",
+}
+
+func TestRenderPackageDiagram(t *testing.T) {
+	projectData := parseTestProject(t, layeredProject)
+
+	collapsed := renderPackageDiagram(projectData, ExternalDepsCollapse)
+	expected := `flowchart LR
+    pkg_example_com_app["example.com/app"]
+    pkg_example_com_app_api["api"]
+    pkg_example_com_app_store["store"]
+    subgraph external ["External dependencies"]
+        ext_github_com_spf13_cobra["github.com/spf13/cobra"]
+    end
+    pkg_example_com_app --> ext_github_com_spf13_cobra
+    pkg_example_com_app --> pkg_example_com_app_api
+    pkg_example_com_app_api --> ext_github_com_spf13_cobra
+    pkg_example_com_app_api --> pkg_example_com_app_store
+    classDef externalDep stroke-dasharray: 5 5
+    class ext_github_com_spf13_cobra externalDep`
+	if collapsed != expected {
+		t.Errorf("Unexpected collapsed package diagram:\n%s\nwant:\n%s", collapsed, expected)
+	}
+
+	hidden := renderPackageDiagram(projectData, ExternalDepsHide)
+	if strings.Contains(hidden, "ext_") {
+		t.Errorf("Expected no external nodes, got:\n%s", hidden)
+	}
+
+	full := renderPackageDiagram(projectData, ExternalDepsFull)
+	for _, node := range []string{`ext_fmt["fmt"]`, `ext_net_http["net/http"]`, `ext_github_com_spf13_cobra_doc["github.com/spf13/cobra/doc"]`} {
+		if !strings.Contains(full, node) {
+			t.Errorf("Expected full diagram to contain %s, got:\n%s", node, full)
+		}
+	}
+}
+
+var monorepoProject = map[string]string{
+	"go.work":              "go 1.22\n\nuse (\n\t.\n\t./api\n)\n",
+	"go.mod":               "module example.com/app\n\ngo 1.22\n\nrequire example.com/app/api v0.0.0\n",
+	"main.go":              "package main\n\nimport \"example.com/app/api/client\"\n",
+	"api/go.mod":           "module example.com/app/api\n\ngo 1.22\n",
+	"api/client/client.go": "package client\n",
+	"tools/go.mod":         "module example.com/tools\n\ngo 1.22\n\nrequire example.com/app v0.0.0\n",
+	"tools/gen/gen.go":     "package gen\n",
+}
+
+func TestRenderModuleDiagram(t *testing.T) {
+	projectData := parseTestProject(t, monorepoProject)
+
+	modules := renderModuleDiagram(projectData)
+	expected := `flowchart LR
+    subgraph workspace ["go.work"]
+        mod_example_com_app["example.com/app<br/>1 packages"]
+        mod_example_com_app_api["example.com/app/api<br/>1 packages"]
+    end
+    mod_example_com_tools["example.com/tools<br/>1 packages"]
+    mod_example_com_app --> mod_example_com_app_api
+    mod_example_com_tools -.-> mod_example_com_app`
+	if modules != expected {
+		t.Errorf("Unexpected module diagram:\n%s\nwant:\n%s", modules, expected)
+	}
+	if errs := ValidateMermaid(modules); len(errs) != 0 {
+		t.Errorf("Expected a valid module diagram, got %v", errs)
+	}
+
+	// The package diagram groups packages by module
+	packages := renderPackageDiagram(projectData, ExternalDepsHide)
+	for _, line := range []string{
+		`    subgraph mod_example_com_app_api ["example.com/app/api"]`,
+		`        pkg_example_com_app_api_client["client"]`,
+		`    pkg_example_com_app --> pkg_example_com_app_api_client`,
+	} {
+		if !strings.Contains(packages+"\n", line+"\n") {
+			t.Errorf("Expected package diagram to contain %q, got:\n%s", line, packages)
+		}
+	}
+	if errs := ValidateMermaid(packages); len(errs) != 0 {
+		t.Errorf("Expected a valid package diagram, got %v:\n%s", errs, packages)
+	}
+
+	// Modules are only generated on request
+	for _, kind := range DiagramKinds() {
+		if kind == DiagramModules {
+			t.Error("Expected the modules diagram to be excluded from the default kinds")
+		}
+	}
+	kinds, err := ParseDiagramKinds("modules")
+	if err != nil || len(kinds) != 1 || kinds[0] != DiagramModules {
+		t.Errorf("Expected the modules kind, got %v, %v", kinds, err)
+	}
+}
+
+func TestGenerateModuleDiagrams(t *testing.T) {
+	projectData := parseTestProject(t, monorepoProject)
+	dirs := func(results map[string]map[string]string) string {
+		var names []string
+		for dir, diagrams := range results {
+			for name := range diagrams {
+				names = append(names, dir+"/"+name)
+			}
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	// Selecting only the overview generates no diagram per module, so the
+	// provider is never called
+	provider := &scriptedProvider{}
+	results, err := GenerateModuleDiagrams(context.Background(), projectData, []DiagramKind{DiagramModules}, Options{Provider: provider, Limiter: NewRateLimiter(0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to generate the module overview: %v", err)
+	}
+	if got := dirs(results); got != "./modules-diagram" {
+		t.Errorf("Expected only the module overview, got %s", got)
+	}
+	if len(provider.prompts) != 0 {
+		t.Errorf("Expected no AI calls, got %d", len(provider.prompts))
+	}
+
+	// Other kinds are generated for every module, next to the overview
+	results, err = GenerateModuleDiagrams(context.Background(), projectData, []DiagramKind{DiagramPackage, DiagramModules}, Options{Deterministic: true})
+	if err != nil {
+		t.Fatalf("Failed to generate module diagrams: %v", err)
+	}
+	if got, want := dirs(results), "./modules-diagram,./package-diagram,api/package-diagram,tools/package-diagram"; got != want {
+		t.Errorf("Expected diagrams %s, got %s", want, got)
+	}
+
+	// Failures name their module
+	_, err = GenerateModuleDiagrams(context.Background(), projectData, []DiagramKind{DiagramClass}, Options{Limiter: NewRateLimiter(0, 1)})
+	var generateErr *GenerateError
+	if !errors.As(err, &generateErr) || len(generateErr.Diagrams) != 3 || !strings.Contains(err.Error(), "module example.com/tools") {
+		t.Errorf("Expected a failed class diagram per module, got %v", err)
+	}
+}
+
+var serviceProject = map[string]string{
+	"go.mod": "module example.com/svc\n\ngo 1.22\n\nrequire github.com/lib/pq v1.10.0\n",
+	"main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/svc/api"
+)
+
+func main() {
+	h := api.NewHandler()
+	fmt.Println(run(h))
+}
+
+func run(h *api.Handler) error {
+	return h.Serve("/")
+}
+`,
+	"api/api.go": `package api
+
+import (
+	"github.com/lib/pq"
+
+	"example.com/svc/store"
+)
+
+type Handler struct {
+	store *store.DB
+}
+
+func NewHandler() *Handler {
+	return &Handler{store: store.Open()}
+}
+
+func (h *Handler) Serve(path string) error {
+	h.log(path)
+	_, err := h.store.Get(path)
+	pq.QuoteIdentifier(path)
+	return err
+}
+
+func (h *Handler) log(msg string) {}
+`,
+	"store/store.go": `package store
+
+type DB struct{}
+
+func Open() *DB { return &DB{} }
+
+func (db *DB) Get(key string) (string, error) { return db.lookup(key), nil }
+
+func (db *DB) lookup(key string) string { return key }
+`,
+}
+
+func TestRenderSequenceDiagram(t *testing.T) {
+	projectData := parseTestProject(t, serviceProject)
+
+	diagram, err := renderSequenceDiagram(projectData, "", 3, ExternalDepsCollapse)
+	if err != nil {
+		t.Fatalf("Failed to render sequence diagram: %v", err)
+	}
+	expected := `sequenceDiagram
+    participant main as main
+    participant api as api
+    participant store as store
+    participant api_Handler as api.Handler
+    participant store_DB as store.DB
+    participant github_com_lib_pq as github.com/lib/pq
+    Note over main: main()
+    main->>api: NewHandler()
+    activate api
+    api->>store: Open()
+    deactivate api
+    main->>main: run()
+    activate main
+    main->>api_Handler: Serve()
+    activate api_Handler
+    api_Handler->>api_Handler: log()
+    api_Handler->>store_DB: Get()
+    api_Handler->>github_com_lib_pq: QuoteIdentifier()
+    deactivate api_Handler
+    deactivate main`
+	if diagram != expected {
+		t.Errorf("Unexpected sequence diagram:\n%s\nwant:\n%s", diagram, expected)
+	}
+
+	// An explicit entry and a deeper walk reach the private store method
+	diagram, err = renderSequenceDiagram(projectData, "api.Handler.Serve", 2, ExternalDepsHide)
+	if err != nil {
+		t.Fatalf("Failed to render sequence diagram: %v", err)
+	}
+	if !strings.Contains(diagram, "store_DB->>store_DB: lookup()") {
+		t.Errorf("Expected nested store call, got:\n%s", diagram)
+	}
+	if strings.Contains(diagram, "pq") {
+		t.Errorf("Expected external calls to be hidden, got:\n%s", diagram)
+	}
+
+	if _, err := renderSequenceDiagram(projectData, "api.Missing", 2, ExternalDepsHide); err == nil {
+		t.Error("Expected an error for an unknown entry function")
+	}
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	// A local OpenAI-compatible server needs no API key
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("Unexpected request path %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("Expected no Authorization header, got %q", auth)
+		}
+
+		var body struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if body.Model != "llama3" || len(body.Messages) != 2 || body.Messages[0].Role != "system" {
+			t.Errorf("Unexpected request body: %+v", body)
+		}
+
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"classDiagram"}}]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("OPENAI_API_KEY", "")
+	provider, err := NewProvider(ProviderConfig{Name: "openai", Model: "llama3", BaseURL: server.URL + "/v1/"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	resp, err := provider.Complete(context.Background(), CompletionRequest{System: "system", Prompt: "prompt"})
+	if err != nil {
+		t.Fatalf("Completion failed: %v", err)
+	}
+	if resp.Text != "classDiagram" {
+		t.Errorf("Expected completion text %q, got %q", "classDiagram", resp.Text)
+	}
+}
+
+func TestGeminiError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int // Expected APIError status, 0 for a permanent error
+	}{
+		{status.Error(codes.ResourceExhausted, "quota exceeded"), http.StatusTooManyRequests},
+		{status.Error(codes.Unavailable, "overloaded"), http.StatusServiceUnavailable},
+		{fmt.Errorf("generate: %w", status.Error(codes.ResourceExhausted, "quota exceeded")), http.StatusTooManyRequests},
+		{&googleapi.Error{Code: http.StatusTooManyRequests, Message: "slow down"}, http.StatusTooManyRequests},
+		{status.Error(codes.InvalidArgument, "bad model"), 0},
+		{errors.New("connection refused"), 0},
+	}
+	for _, test := range tests {
+		var apiErr *APIError
+		err := geminiError(test.err)
+		if test.status == 0 {
+			if errors.As(err, &apiErr) {
+				t.Errorf("Expected %v to stay a permanent error, got %v", test.err, err)
+			}
+			continue
+		}
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != test.status {
+			t.Errorf("Expected %v to map to status %d, got %v", test.err, test.status, err)
+		}
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("OPENAI_BASE_URL", "")
+
+	if _, err := NewProvider(ProviderConfig{Name: "anthropic"}); !errors.Is(err, ErrMissingAPIKey) {
+		t.Errorf("Expected ErrMissingAPIKey, got %v", err)
+	}
+	if _, err := NewProvider(ProviderConfig{Name: "openai"}); !errors.Is(err, ErrMissingAPIKey) {
+		t.Errorf("Expected ErrMissingAPIKey, got %v", err)
+	}
+	if _, err := NewProvider(ProviderConfig{Name: "bogus"}); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+
+	provider, err := NewProvider(ProviderConfig{Name: "Anthropic", APIKey: "key"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if provider.Name() != "anthropic" || provider.Model() != defaultAnthropicModel {
+		t.Errorf("Unexpected provider %s/%s", provider.Name(), provider.Model())
+	}
+}
+
+func TestParseDiagramKinds(t *testing.T) {
+	kinds, err := ParseDiagramKinds("Sequence, class,sequence")
+	if err != nil {
+		t.Fatalf("Failed to parse diagram kinds: %v", err)
+	}
+	if len(kinds) != 2 || kinds[0] != DiagramSequence || kinds[1] != DiagramClass {
+		t.Errorf("Unexpected diagram kinds %v", kinds)
+	}
+
+	if kinds, _ := ParseDiagramKinds("all"); len(kinds) != len(DiagramKinds()) {
+		t.Errorf("Expected every diagram kind, got %v", kinds)
+	}
+	if _, err := ParseDiagramKinds("class,state"); err == nil {
+		t.Error("Expected an error for an unknown diagram type")
+	}
+}
+
+func TestGenerateDiagramsSelection(t *testing.T) {
+	projectData := parseTestProject(t, serviceProject)
+
+	diagrams, err := GenerateDiagrams(context.Background(), projectData, []DiagramKind{DiagramPackage}, Options{Deterministic: true})
+	if err != nil {
+		t.Fatalf("Failed to generate diagrams: %v", err)
+	}
+	if len(diagrams) != 1 || !strings.Contains(diagrams["package-diagram"], "flowchart LR") {
+		t.Errorf("Expected only the package diagram, got %v", diagrams)
+	}
+
+	if _, err := GenerateDiagrams(context.Background(), projectData, []DiagramKind{"state"}, Options{Deterministic: true}); err == nil {
+		t.Error("Expected an error for an unknown diagram type")
+	}
+}
+
+func TestValidateMermaid(t *testing.T) {
+	// Everything mermgen renders itself must pass validation
+	valid := map[string]string{
+		"class":    renderClassDiagram(parseTestProject(t, shapesProject)),
+		"package":  renderPackageDiagram(parseTestProject(t, serviceProject), ExternalDepsFull),
+		"fallback": extractMermaidCode(createFallbackDiagram("class")),
+		"flowchart": `graph TD
+    A[Start] --> B{Is it?}
+    B -->|Yes (really)| C>Done]
+    B -- No --> D(("Retry [x]"))`,
+		"sequence": `sequenceDiagram
+    autonumber
+    participant A as Client
+    actor U
+    A->>+B: request()
+    alt cached
+        B-->>A: hit
+    else miss
+        B->>C: load
+    end
+    B-->>-A: response
+    Note right of A: done`,
+	}
+	sequence, err := renderSequenceDiagram(parseTestProject(t, serviceProject), "", 3, ExternalDepsFull)
+	if err != nil {
+		t.Fatalf("Failed to render sequence diagram: %v", err)
+	}
+	valid["rendered sequence"] = sequence
+	for _, kind := range []string{"package", "sequence"} {
+		valid["fallback "+kind] = extractMermaidCode(createFallbackDiagram(kind))
+	}
+
+	for name, code := range valid {
+		if errs := ValidateMermaid(code); len(errs) != 0 {
+			t.Errorf("Expected %s diagram to be valid, got %v:\n%s", name, errs, code)
+		}
+	}
+
+	invalid := []struct {
+		code string
+		line int
+	}{
+		{"", 1},
+		{"pie\n    \"a\" : 1", 1},
+		{"classDiagram\n    class A {\n        +Run() error\n", 2},
+		{"classDiagram\n    class A\n    A -> B\n", 3},
+		{"classDiagram\n    class A {\n        +Run(ctx error\n    }", 3},
+		{"flowchart LR\n    subgraph one\n        a[\"A\"] --> b[B\n", 3},
+		{"flowchart LR\n    a --> b\n    end", 3},
+		{"sequenceDiagram\n    A->>B: hi\n    loop forever\n        B->>A: again", 3},
+		{"sequenceDiagram\n    A->>B: hi\n    deactivate B", 3},
+		{"sequenceDiagram\n    A calls B", 2},
+		{"Here is your diagram:\nclassDiagram\n    class A", 1},
+	}
+	for _, test := range invalid {
+		errs := ValidateMermaid(test.code)
+		if len(errs) == 0 {
+			t.Errorf("Expected errors for:\n%s", test.code)
+			continue
+		}
+		if errs[0].Line != test.line {
+			t.Errorf("Expected an error on line %d, got %v for:\n%s", test.line, errs, test.code)
+		}
+	}
+}
+
+// scriptedProvider returns canned responses in order and records the prompts it received
+type scriptedProvider struct {
+	mu        sync.Mutex
+	match     map[string]string // Responses for prompts containing the key, before the ordered responses
+	responses []string
+	prompts   []string
+}
+
+func (p *scriptedProvider) Name() string  { return "scripted" }
+func (p *scriptedProvider) Model() string { return "test" }
+
+func (p *scriptedProvider) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prompts = append(p.prompts, req.Prompt)
+	for key, text := range p.match {
+		if strings.Contains(req.Prompt, key) {
+			return &CompletionResponse{Text: text}, nil
+		}
+	}
+	if len(p.responses) == 0 {
+		return nil, errors.New("no more responses")
+	}
+	text := p.responses[0]
+	p.responses = p.responses[1:]
+	return &CompletionResponse{Text: text}, nil
+}
+
+func TestCallAIRepairsInvalidDiagram(t *testing.T) {
+	provider := &scriptedProvider{responses: []string{
+		"```mermaid\nsequenceDiagram\n    A calls B\n```",
+		"```mermaid\nsequenceDiagram\n    A->>B: call()\n```",
+	}}
+	diagram, err := callAI(context.Background(), Options{Provider: provider}, map[string]interface{}{"task": "test"}, "sequence")
+	if err != nil {
+		t.Fatalf("callAI failed: %v", err)
+	}
+	if !strings.Contains(diagram, "A->>B: call()") {
+		t.Errorf("Expected the repaired diagram, got:\n%s", diagram)
+	}
+	if len(provider.prompts) != 2 || !strings.Contains(provider.prompts[1], "line 2: unrecognized statement") {
+		t.Errorf("Expected the repair prompt to include the validation errors, got %q", provider.prompts)
+	}
+
+	// Diagrams that stay broken fail after the bounded number of repairs
+	provider = &scriptedProvider{responses: []string{"not mermaid", "still not", "nope", "unused"}}
+	diagram, err = callAI(context.Background(), Options{Provider: provider}, map[string]interface{}{"task": "test"}, "class")
+	var invalidErr *InvalidDiagramError
+	if !errors.As(err, &invalidErr) || diagram != "" || len(provider.prompts) != maxRepairAttempts+1 {
+		t.Errorf("Expected an invalid diagram error after %d attempts, got %v after %d prompts", maxRepairAttempts+1, err, len(provider.prompts))
+	}
+}
+
+func TestGenerateDiagramsFallback(t *testing.T) {
+	projectData := parseTestProject(t, serviceProject)
+	newProvider := func() Provider {
+		return &scriptedProvider{match: map[string]string{
+			"Mermaid class diagram":   "```mermaid\nclassDiagram\n    class Handler\n```",
+			"Mermaid package diagram": "I cannot draw this project.",
+			// The sequence diagram gets no response at all
+		}}
+	}
+	kinds := []DiagramKind{DiagramClass, DiagramPackage, DiagramSequence}
+
+	tests := []struct {
+		policy   FallbackPolicy
+		diagrams []string
+		failed   bool
+	}{
+		{FallbackNone, []string{"class-diagram"}, true},
+		{FallbackPlaceholder, []string{"class-diagram", "package-diagram", "sequence-diagram"}, false},
+		{FallbackDeterministic, []string{"class-diagram", "package-diagram", "sequence-diagram"}, false},
+	}
+	for _, test := range tests {
+		var logOutput bytes.Buffer
+		opts := Options{
+			Provider: newProvider(),
+			Fallback: test.policy,
+			Limiter:  NewRateLimiter(0, 1),
+			Logger:   slog.New(slog.NewJSONHandler(&logOutput, nil)),
+		}
+		diagrams, err := GenerateDiagrams(context.Background(), projectData, kinds, opts)
+
+		var names []string
+		for name := range diagrams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if strings.Join(names, ",") != strings.Join(test.diagrams, ",") {
+			t.Errorf("%s: expected diagrams %v, got %v", test.policy, test.diagrams, names)
+		}
+
+		// The class diagram succeeded, the others are reported with their causes
+		var generateErr *GenerateError
+		if !errors.As(err, &generateErr) || len(generateErr.Diagrams) != 2 || generateErr.Failed() != test.failed {
+			t.Fatalf("%s: expected two degraded diagrams, got %v", test.policy, err)
+		}
+		var invalidErr *InvalidDiagramError
+		if kind := generateErr.Diagrams[0].Kind; kind != DiagramPackage || !errors.As(generateErr.Diagrams[0], &invalidErr) {
+			t.Errorf("%s: expected the package diagram to be invalid, got %v", test.policy, generateErr.Diagrams[0])
+		}
+		if generateErr.Diagrams[1].Kind != DiagramSequence || !strings.Contains(generateErr.Diagrams[1].Error(), "all API retries failed") {
+			t.Errorf("%s: expected the sequence diagram to fail, got %v", test.policy, generateErr.Diagrams[1])
+		}
+		for _, diagramErr := range generateErr.Diagrams {
+			if want := test.policy; diagramErr.Fallback != want {
+				t.Errorf("%s: expected fallback %s, got %s", test.policy, want, diagramErr.Fallback)
+			}
+		}
+		if !strings.Contains(logOutput.String(), `"kind":"package"`) {
+			t.Errorf("%s: expected the degraded package diagram in the log, got:\n%s", test.policy, logOutput.String())
+		}
+
+		switch test.policy {
+		case FallbackPlaceholder:
+			if !strings.Contains(diagrams["package-diagram"], "**Placeholder:**") {
+				t.Errorf("Expected a marked placeholder, got:\n%s", diagrams["package-diagram"])
+			}
+		case FallbackDeterministic:
+			if !strings.Contains(diagrams["package-diagram"], "pkg_example_com_svc_api") {
+				t.Errorf("Expected the rendered package diagram, got:\n%s", diagrams["package-diagram"])
+			}
+		}
+	}
+
+	// Without a provider, diagrams that need one fail unless rendered deterministically
+	_, err := GenerateDiagrams(context.Background(), projectData, []DiagramKind{DiagramClass}, Options{})
+	if !errors.Is(err, ErrNoProvider) {
+		t.Errorf("Expected ErrNoProvider, got %v", err)
+	}
+}
+
+func TestGenerateDiagramsSummarizesLargeProjects(t *testing.T) {
+	projectData := parseTestProject(t, serviceProject)
+	provider := &scriptedProvider{responses: []string{
+		"```json\n{\"purpose\": \"Entry point\", \"functions\": [\"main()\"], \"interactions\": [\"run -> api.Handler.Serve\"]}\n```",
+		`{"purpose": "HTTP handlers", "types": [{"name": "Handler", "kind": "struct", "relationships": ["has *store.DB"]}]}`,
+		"not a summary",
+	}, match: map[string]string{
+		"Mermaid class diagram":    "```mermaid\nclassDiagram\n    class Handler\n```",
+		"Mermaid sequence diagram": "```mermaid\nsequenceDiagram\n    A->>B: call()\n```",
+	}}
+
+	// The sources exceed the prompt budget, so each package is summarized
+	// once and both diagrams are generated from the summaries
+	opts := Options{Provider: provider, Budget: TokenBudget{PromptTokens: 150}, Limiter: NewRateLimiter(0, 1)}
+	diagrams, err := GenerateDiagrams(context.Background(), projectData, []DiagramKind{DiagramClass, DiagramSequence}, opts)
+	if !strings.Contains(diagrams["class-diagram"], "class Handler") || !strings.Contains(diagrams["sequence-diagram"], "A->>B") {
+		t.Errorf("Expected the generated diagrams, got %v", diagrams)
+	}
+
+	// The store summary failed, so both diagrams are reported as degraded
+	var generateErr *GenerateError
+	var summaryErr *SummaryError
+	if !errors.As(err, &generateErr) || len(generateErr.Diagrams) != 2 || generateErr.Failed() {
+		t.Fatalf("Expected two degraded diagrams, got %v", err)
+	}
+	for _, diagramErr := range generateErr.Diagrams {
+		if diagramErr.Fallback != FallbackSymbols || !errors.As(diagramErr, &summaryErr) || !strings.Contains(diagramErr.Error(), "example.com/svc/store") {
+			t.Errorf("Expected the failed store summary, got %v", diagramErr)
+		}
+	}
+
+	if len(provider.prompts) != 5 {
+		t.Fatalf("Expected 3 summaries and 2 diagrams, got %d prompts", len(provider.prompts))
+	}
+	for i, importPath := range []string{"example.com/svc", "example.com/svc/api", "example.com/svc/store"} {
+		if !strings.Contains(provider.prompts[i], "Summarize the Go package "+importPath+" ") {
+			t.Errorf("Expected prompt %d to summarize %s, got:\n%s", i, importPath, provider.prompts[i])
+		}
+	}
+	for _, prompt := range provider.prompts[3:] {
+		for _, want := range []string{"packageSummaries", "HTTP handlers", "run -\\u003e api.Handler.Serve", "Package store", "lookup"} {
+			if !strings.Contains(prompt, want) {
+				t.Errorf("Expected diagram prompt to contain %q, got:\n%s", want, prompt)
+			}
+		}
+		if strings.Contains(prompt, "fileInfo") {
+			t.Errorf("Expected no sources in the diagram prompt, got:\n%s", prompt)
+		}
+	}
+
+	// Projects that fit are sent as they are
+	provider = &scriptedProvider{responses: []string{"```mermaid\nclassDiagram\n    class Handler\n```"}}
+	opts = Options{Provider: provider, Limiter: NewRateLimiter(0, 1)}
+	if _, err := GenerateDiagrams(context.Background(), projectData, []DiagramKind{DiagramClass}, opts); err != nil {
+		t.Fatalf("GenerateDiagrams failed: %v", err)
+	}
+	if len(provider.prompts) != 1 || !strings.Contains(provider.prompts[0], "fileInfo") {
+		t.Fatalf("Expected a single prompt with the sources, got %q", provider.prompts)
+	}
+	// Files are named by their path in the repository, not the checkout location
+	if !strings.Contains(provider.prompts[0], "api/api.go") || strings.Contains(provider.prompts[0], projectData.Root) {
+		t.Errorf("Expected repository relative paths in the prompt, got:\n%s", provider.prompts[0])
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "h"}, // é is two bytes
+		{"héllo", 3, "hé"},
+		{"日本", 4, "日"},
+		{"日本", 2, ""},
+	}
+	for _, test := range tests {
+		got := truncateUTF8(test.text, test.n)
+		if got != test.want || !utf8.ValidString(got) {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", test.text, test.n, got, test.want)
+		}
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	cache := &ResponseCache{Dir: t.TempDir()}
+	prompt := map[string]interface{}{"task": "test"}
+	valid := "```mermaid\nsequenceDiagram\n    A->>B: call()\n```"
+
+	// A response that needs a repair is not cached, the repaired diagram is
+	// cached for the original request
+	scripted := &scriptedProvider{responses: []string{"```mermaid\nsequenceDiagram\n    A calls B\n```", valid}}
+	opts := Options{Provider: scripted, Cache: cache, Limiter: NewRateLimiter(0, 1)}
+	diagram, err := callAI(context.Background(), opts, prompt, "sequence")
+	if err != nil || !strings.Contains(diagram, "A->>B: call()") {
+		t.Fatalf("Expected the repaired diagram, got %q, %v", diagram, err)
+	}
+
+	// A repeated request is answered from disk without waiting for the rate limiter
+	opts.Limiter = NewRateLimiter(time.Hour, 1)
+	if again, err := callAI(context.Background(), opts, prompt, "sequence"); err != nil || again != diagram || len(scripted.prompts) != 2 {
+		t.Fatalf("Expected a cache hit, got %q, %v after %d calls", again, err, len(scripted.prompts))
+	}
+
+	// Diagrams that stay invalid are never cached
+	broken := &scriptedProvider{responses: []string{"not mermaid", "still not", "nope"}}
+	opts = Options{Provider: broken, Cache: cache, Limiter: NewRateLimiter(0, 1)}
+	if _, err := callAI(context.Background(), opts, prompt, "class"); err == nil {
+		t.Fatal("Expected an invalid diagram")
+	}
+	if entries, _ := filepath.Glob(filepath.Join(cache.Dir, "*", "*.json")); len(entries) != 1 {
+		t.Errorf("Expected only the valid diagram to be cached, found %d entries", len(entries))
+	}
+
+	// Entries that do not validate, e.g. written by older versions, are requested again
+	scripted = &scriptedProvider{responses: []string{`{"purpose": "fresh"}`}}
+	opts = Options{Provider: scripted, Cache: cache, Limiter: NewRateLimiter(0, 1)}
+	request := CompletionRequest{System: "system", Prompt: "prompt"}
+	var summary PackageSummary
+	if err := cache.Put(scripted, request, "not json"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := completeJSON(context.Background(), opts, request, &summary); err != nil || summary.Purpose != "fresh" || len(scripted.prompts) != 1 {
+		t.Fatalf("Expected a fresh response, got %+v, %v after %d calls", summary, err, len(scripted.prompts))
+	}
+	if text, ok := cache.Get(scripted, request); !ok || text != `{"purpose": "fresh"}` {
+		t.Errorf("Expected the usable response to replace the entry, got %q, %v", text, ok)
+	}
+
+	// Any change to the inputs misses
+	if _, ok := cache.Get(scripted, CompletionRequest{System: "system", Prompt: "prompt", MaxTokens: 10}); ok {
+		t.Error("Expected a different token limit to miss the cache")
+	}
+	if _, ok := cache.Get(&scriptedProvider{}, CompletionRequest{System: "other", Prompt: "prompt"}); ok {
+		t.Error("Expected a different system prompt to miss the cache")
+	}
+
+	// Refreshing requests the completion again and replaces the entry
+	cache.Refresh = true
+	scripted.responses = []string{`{"purpose": "refreshed"}`}
+	if err := completeJSON(context.Background(), opts, request, &summary); err != nil || summary.Purpose != "refreshed" {
+		t.Fatalf("Expected a refreshed response, got %+v, %v", summary, err)
+	}
+	cache.Refresh = false
+	if text, ok := cache.Get(scripted, request); !ok || text != `{"purpose": "refreshed"}` {
+		t.Errorf("Expected the refreshed entry, got %q, %v", text, ok)
+	}
+
+	if err := cache.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if _, ok := cache.Get(scripted, request); ok {
+		t.Error("Expected an empty cache after Clear")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(time.Second, 2)
+	limiter.now = func() time.Time { return now }
+
+	// The bucket starts full and refills one token per interval
+	for i := 0; i < 2; i++ {
+		if delay := limiter.reserve(); delay != 0 {
+			t.Fatalf("Expected call %d to pass immediately, got delay %v", i, delay)
+		}
+	}
+	if delay := limiter.reserve(); delay != time.Second {
+		t.Errorf("Expected to wait one interval, got %v", delay)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if delay := limiter.reserve(); delay != 500*time.Millisecond {
+		t.Errorf("Expected to wait for the rest of the interval, got %v", delay)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if delay := limiter.reserve(); delay != 0 {
+		t.Errorf("Expected a refilled token, got delay %v", delay)
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		pause  time.Duration
+	}{
+		{"no headers", http.Header{}, 0},
+		{"retry-after seconds", http.Header{"Retry-After": {"5"}}, 5 * time.Second},
+		{"retry-after date", http.Header{"Retry-After": {now.Add(7 * time.Second).Format(http.TimeFormat)}}, 7 * time.Second},
+		{"anthropic limit left", http.Header{
+			"Anthropic-Ratelimit-Requests-Remaining": {"3"},
+			"Anthropic-Ratelimit-Requests-Reset":     {now.Add(time.Minute).Format(time.RFC3339)},
+		}, 0},
+		{"anthropic limit exhausted", http.Header{
+			"Anthropic-Ratelimit-Requests-Remaining":     {"5"},
+			"Anthropic-Ratelimit-Input-Tokens-Remaining": {"0"},
+			"Anthropic-Ratelimit-Input-Tokens-Reset":     {now.Add(20 * time.Second).Format(time.RFC3339)},
+		}, 20 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := NewRateLimiter(0, 1)
+			limiter.now = func() time.Time { return now }
+			if paused := limiter.Update(test.header); paused != (test.pause > 0) {
+				t.Errorf("Update reported %v, expected a pause of %v", paused, test.pause)
+			}
+			if delay := limiter.reserve(); delay != test.pause {
+				t.Errorf("Expected a pause of %v, got %v", test.pause, delay)
+			}
+		})
+	}
+
+	// Waiting is cancelled with the context
+	limiter = NewRateLimiter(0, 1)
+	limiter.Backoff(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+}
+
+func TestGenerateDiagramsConcurrently(t *testing.T) {
+	projectData := parseTestProject(t, serviceProject)
+	provider := &scriptedProvider{match: map[string]string{
+		"Mermaid class diagram":    "```mermaid\nclassDiagram\n    class Handler\n```",
+		"Mermaid package diagram":  "```mermaid\nflowchart LR\n    api --> store\n```",
+		"Mermaid sequence diagram": "```mermaid\nsequenceDiagram\n    A->>B: call()\n```",
+	}}
+
+	// The three calls share one token and a short interval, so they are
+	// spread out but still overlap with the generation of the others
+	opts := Options{Provider: provider, Limiter: NewRateLimiter(10*time.Millisecond, 1)}
+	diagrams, err := GenerateDiagrams(context.Background(), projectData, nil, opts)
+	if err != nil {
+		t.Fatalf("GenerateDiagrams failed: %v", err)
+	}
+	if len(diagrams) != 3 || len(provider.prompts) != 3 {
+		t.Fatalf("Expected 3 diagrams from 3 calls, got %d diagrams from %d calls", len(diagrams), len(provider.prompts))
+	}
+	for name, want := range map[string]string{"class-diagram": "class Handler", "package-diagram": "api --> store", "sequence-diagram": "A->>B"} {
+		if !strings.Contains(diagrams[name], want) {
+			t.Errorf("Expected %s to contain %q, got:\n%s", name, want, diagrams[name])
+		}
+	}
+}
+
+// blockingProvider never answers, like a hung API call
+type blockingProvider struct{}
+
+func (blockingProvider) Name() string  { return "blocking" }
+func (blockingProvider) Model() string { return "test" }
+
+func (blockingProvider) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGenerateDiagramsCancelled(t *testing.T) {
+	projectData := parseTestProject(t, serviceProject)
+
+	// A timeout ends the run with an error instead of fallback diagrams
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	opts := Options{Provider: blockingProvider{}, Limiter: NewRateLimiter(0, 1)}
+	diagrams, err := GenerateDiagrams(ctx, projectData, nil, opts)
+	if !errors.Is(err, context.DeadlineExceeded) || diagrams != nil {
+		t.Errorf("Expected the deadline error, got %v and %d diagrams", err, len(diagrams))
+	}
+}
@@ -0,0 +1,181 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Nurozen/mermgen/parser"
+)
+
+// moduleEdge is a dependency between two modules of the project
+type moduleEdge struct {
+	From, To string // Module paths
+	Imports  bool   // Packages of From import packages of To, not just a go.mod requirement
+}
+
+// buildModuleGraph resolves the dependencies between the project's modules
+// from package imports and go.mod requirements, sorted by module path
+func buildModuleGraph(projectData *parser.RawProjectData) []moduleEdge {
+	edges := make(map[[2]string]bool)
+	for _, pkg := range projectData.Packages {
+		if pkg.Module == "" {
+			continue
+		}
+		for _, imp := range pkg.Imports {
+			if module := projectData.ModuleOf(imp); module != nil && module.Path != pkg.Module {
+				edges[[2]string{pkg.Module, module.Path}] = true
+			}
+		}
+	}
+	for _, module := range projectData.Modules {
+		for _, req := range module.Requires {
+			if projectData.ModuleByPath(req) == nil || req == module.Path {
+				continue
+			}
+			if _, ok := edges[[2]string{module.Path, req}]; !ok {
+				edges[[2]string{module.Path, req}] = false
+			}
+		}
+	}
+
+	graph := make([]moduleEdge, 0, len(edges))
+	for edge, imports := range edges {
+		graph = append(graph, moduleEdge{From: edge[0], To: edge[1], Imports: imports})
+	}
+	sort.Slice(graph, func(i, j int) bool {
+		if graph[i].From != graph[j].From {
+			return graph[i].From < graph[j].From
+		}
+		return graph[i].To < graph[j].To
+	})
+	return graph
+}
+
+// renderModuleDiagram builds a Mermaid flowchart giving an overview of the
+// modules of a multi-module repository. Solid edges are package imports,
+// dotted edges requirements without imports. Modules used by the go.work
+// file are grouped in a workspace subgraph.
+func renderModuleDiagram(projectData *parser.RawProjectData) string {
+	workspaceDirs := make(map[string]bool)
+	if projectData.Workspace != nil {
+		for _, use := range projectData.Workspace.Use {
+			workspaceDirs[path.Join(projectData.Workspace.Dir, filepath.ToSlash(use))] = true
+		}
+	}
+
+	packageCounts := make(map[string]int)
+	for _, pkg := range projectData.Packages {
+		packageCounts[pkg.Module]++
+	}
+
+	lines := []string{"flowchart LR"}
+	var inWorkspace, outside []string
+	for _, module := range projectData.Modules {
+		node := fmt.Sprintf("mod_%s[\"%s<br/>%d packages\"]", mermaidID(module.Path), module.Path, packageCounts[module.Path])
+		if workspaceDirs[module.Dir] {
+			inWorkspace = append(inWorkspace, node)
+		} else {
+			outside = append(outside, node)
+		}
+	}
+	if len(inWorkspace) > 0 {
+		lines = append(lines, "    subgraph workspace [\"go.work\"]")
+		for _, node := range inWorkspace {
+			lines = append(lines, "        "+node)
+		}
+		lines = append(lines, "    end")
+	}
+	for _, node := range outside {
+		lines = append(lines, "    "+node)
+	}
+
+	for _, edge := range buildModuleGraph(projectData) {
+		arrow := "-->"
+		if !edge.Imports {
+			arrow = "-.->"
+		}
+		lines = append(lines, fmt.Sprintf("    mod_%s %s mod_%s", mermaidID(edge.From), arrow, mermaidID(edge.To)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// generateModuleDiagram renders the module overview. The graph is exact and
+// small, so it is always rendered from the parsed modules.
+func generateModuleDiagram(ctx context.Context, projectData *parser.RawProjectData, opts Options) (string, error) {
+	return formatDiagram("modules", renderModuleDiagram(projectData)), nil
+}
+
+// GenerateModuleDiagrams generates the requested diagrams for every module of
+// a multi-module project on its own, plus the module overview, keyed by the
+// directory of the module relative to the project root ("." for the
+// overview). An empty kinds list selects every default diagram per module;
+// selecting only DiagramModules generates just the overview.
+//
+// As with GenerateDiagrams, diagrams that fail are returned in a
+// *GenerateError naming their module, alongside the other diagrams.
+func GenerateModuleDiagrams(ctx context.Context, projectData *parser.RawProjectData, kinds []DiagramKind, opts Options) (map[string]map[string]string, error) {
+	if len(kinds) == 0 {
+		kinds = DiagramKinds()
+	}
+	var moduleKinds []DiagramKind
+	for _, kind := range kinds {
+		if kind != DiagramModules {
+			moduleKinds = append(moduleKinds, kind)
+		}
+	}
+	if opts.Limiter == nil {
+		// Shared by the diagrams of every module
+		opts.Limiter = NewRateLimiter(DefaultRequestInterval, 1)
+		opts.Limiter.Logger = opts.Logger
+	}
+
+	results := make(map[string]map[string]string)
+	var diagramErrs []*DiagramError
+	collect := func(dir, module string, diagrams map[string]string, err error) error {
+		var generateErr *GenerateError
+		if err != nil && !errors.As(err, &generateErr) {
+			if module != "" {
+				return fmt.Errorf("module %s: %w", module, err)
+			}
+			return err
+		}
+		if results[dir] == nil {
+			results[dir] = make(map[string]string)
+		}
+		for name, diagram := range diagrams {
+			results[dir][name] = diagram
+		}
+		if generateErr != nil {
+			for _, diagramErr := range generateErr.Diagrams {
+				if module != "" {
+					diagramErr.Err = fmt.Errorf("module %s: %w", module, diagramErr.Err)
+				}
+				diagramErrs = append(diagramErrs, diagramErr)
+			}
+		}
+		return nil
+	}
+
+	// Without any per-module kind only the overview is generated, since an
+	// empty selection would mean every diagram
+	if len(moduleKinds) > 0 {
+		for _, module := range projectData.Modules {
+			opts.logger().Info("Generating diagrams of module", "module", module.Path)
+			diagrams, err := GenerateDiagrams(ctx, projectData.ModuleProject(module.Path), moduleKinds, opts)
+			if err := collect(module.Dir, module.Path, diagrams, err); err != nil {
+				return results, err
+			}
+		}
+	}
+	diagrams, err := GenerateDiagrams(ctx, projectData, []DiagramKind{DiagramModules}, opts)
+	if err := collect(".", "", diagrams, err); err != nil {
+		return results, err
+	}
+	return results, newGenerateError(diagramErrs)
+}
@@ -0,0 +1,94 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultOpenAIModel   = "gpt-4o"
+	defaultOpenAIBaseURL = "https://api.openai.com/v1"
+)
+
+// openAIProvider talks to any OpenAI-compatible chat completions endpoint,
+// which includes local servers such as Ollama and llama.cpp
+type openAIProvider struct {
+	apiKey  string
+	model   string
+	baseURL string
+}
+
+func newOpenAIProvider(cfg ProviderConfig) (*openAIProvider, error) {
+	provider := &openAIProvider{
+		apiKey:  cfg.APIKey,
+		model:   cfg.Model,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+	}
+	if provider.apiKey == "" {
+		provider.apiKey = firstEnv("OPENAI_API_KEY")
+	}
+	if provider.baseURL == "" {
+		provider.baseURL = strings.TrimSuffix(firstEnv("OPENAI_BASE_URL"), "/")
+	}
+	if provider.baseURL == "" {
+		provider.baseURL = defaultOpenAIBaseURL
+	}
+	if provider.model == "" {
+		provider.model = defaultOpenAIModel
+	}
+
+	// Self-hosted servers usually accept unauthenticated requests
+	if provider.apiKey == "" && provider.baseURL == defaultOpenAIBaseURL {
+		return nil, fmt.Errorf("openai: %w (set OPENAI_API_KEY or point -base-url at a local server)", ErrMissingAPIKey)
+	}
+	return provider, nil
+}
+
+func (p *openAIProvider) Name() string  { return "openai" }
+func (p *openAIProvider) Model() string { return p.model }
+
+func (p *openAIProvider) Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error) {
+	requestBody := map[string]interface{}{
+		"model": p.model,
+		"messages": []map[string]interface{}{
+			{
+				"role":    "system",
+				"content": req.System,
+			},
+			{
+				"role":    "user",
+				"content": req.Prompt,
+			},
+		},
+	}
+	if req.MaxTokens > 0 {
+		requestBody["max_tokens"] = req.MaxTokens
+	}
+
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	responseBody, header, err := postJSON(ctx, p.baseURL+"/chat/completions", headers, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return nil, fmt.Errorf("error parsing response: %w", err)
+	}
+
+	if len(response.Choices) == 0 {
+		return &CompletionResponse{Header: header}, nil
+	}
+	return &CompletionResponse{Text: response.Choices[0].Message.Content, Header: header}, nil
+}
@@ -0,0 +1,192 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Nurozen/mermgen/parser"
+)
+
+// ExternalDeps controls how dependencies outside the project appear in the package diagram
+type ExternalDeps string
+
+const (
+	ExternalDepsHide     ExternalDeps = "hide"     // Only the project's own packages
+	ExternalDepsCollapse ExternalDeps = "collapse" // One node per third-party module
+	ExternalDepsFull     ExternalDeps = "full"     // Every imported third-party and standard library package
+)
+
+// ParseExternalDeps validates an ExternalDeps value, defaulting to collapse
+func ParseExternalDeps(value string) (ExternalDeps, error) {
+	switch ExternalDeps(value) {
+	case "":
+		return ExternalDepsCollapse, nil
+	case ExternalDepsHide, ExternalDepsCollapse, ExternalDepsFull:
+		return ExternalDeps(value), nil
+	}
+	return "", fmt.Errorf("invalid external dependency mode %q (expected hide, collapse or full)", value)
+}
+
+// packageNode is a node of the package dependency graph
+type packageNode struct {
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+	Kind     string `json:"kind"`             // An ImportKind, or "module" for collapsed third-party modules
+	Module   string `json:"module,omitempty"` // Module of an internal package in multi-module projects
+	Internal bool   `json:"-"`
+}
+
+// packageGraph is the resolved package dependency graph of a project
+type packageGraph struct {
+	Nodes []packageNode `json:"nodes"`
+	Edges [][2]string   `json:"edges"` // Pairs of node ids, importer first
+}
+
+// buildPackageGraph resolves every package's imports into a dependency graph.
+// Nodes and edges are sorted so the graph is stable for the same input.
+func buildPackageGraph(projectData *parser.RawProjectData, externalDeps ExternalDeps) packageGraph {
+	if externalDeps == "" {
+		externalDeps = ExternalDepsCollapse
+	}
+
+	importPaths := make([]string, 0, len(projectData.Packages))
+	for importPath := range projectData.Packages {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+
+	nodes := make(map[string]packageNode)
+	edges := make(map[[2]string]bool)
+
+	for _, importPath := range importPaths {
+		pkg := projectData.Packages[importPath]
+		from := "pkg_" + mermaidID(importPath)
+		nodes[from] = packageNode{
+			ID:       from,
+			Label:    packageLabel(projectData, pkg),
+			Kind:     string(parser.ImportInternal),
+			Internal: true,
+		}
+		if len(projectData.Modules) > 1 {
+			node := nodes[from]
+			node.Module = pkg.Module
+			nodes[from] = node
+		}
+
+		for _, imp := range pkg.Imports {
+			var to string
+			switch kind := projectData.ClassifyImport(imp); {
+			case kind == parser.ImportInternal:
+				to = "pkg_" + mermaidID(imp)
+				if _, ok := projectData.Packages[imp]; !ok {
+					continue // Import of a package that was not parsed
+				}
+			case externalDeps == ExternalDepsFull:
+				to = "ext_" + mermaidID(imp)
+				nodes[to] = packageNode{ID: to, Label: imp, Kind: string(kind)}
+			case externalDeps == ExternalDepsCollapse && kind == parser.ImportExternal:
+				module := projectData.ExternalModule(imp)
+				to = "ext_" + mermaidID(module)
+				nodes[to] = packageNode{ID: to, Label: module, Kind: "module"}
+			default:
+				continue
+			}
+
+			if to != from {
+				edges[[2]string{from, to}] = true
+			}
+		}
+	}
+
+	graph := packageGraph{}
+	for _, node := range nodes {
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		if graph.Nodes[i].Internal != graph.Nodes[j].Internal {
+			return graph.Nodes[i].Internal
+		}
+		if graph.Nodes[i].Module != graph.Nodes[j].Module {
+			return graph.Nodes[i].Module < graph.Nodes[j].Module
+		}
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	for edge := range edges {
+		graph.Edges = append(graph.Edges, edge)
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i][0] != graph.Edges[j][0] {
+			return graph.Edges[i][0] < graph.Edges[j][0]
+		}
+		return graph.Edges[i][1] < graph.Edges[j][1]
+	})
+
+	return graph
+}
+
+// renderPackageDiagram builds a Mermaid flowchart of the package dependency
+// graph. The packages of multi-module projects are grouped by module.
+func renderPackageDiagram(projectData *parser.RawProjectData, externalDeps ExternalDeps) string {
+	graph := buildPackageGraph(projectData, externalDeps)
+
+	lines := []string{"flowchart LR"}
+	var externalIDs []string
+	module := ""
+	for _, node := range graph.Nodes {
+		if node.Internal {
+			if node.Module != module {
+				if module != "" {
+					lines = append(lines, "    end")
+				}
+				module = node.Module
+				lines = append(lines, fmt.Sprintf("    subgraph mod_%s [\"%s\"]", mermaidID(module), module))
+			}
+			indent := "    "
+			if module != "" {
+				indent += "    "
+			}
+			lines = append(lines, fmt.Sprintf("%s%s[\"%s\"]", indent, node.ID, node.Label))
+			continue
+		}
+		if module != "" {
+			lines = append(lines, "    end")
+			module = ""
+		}
+		if len(externalIDs) == 0 {
+			lines = append(lines, "    subgraph external [\"External dependencies\"]")
+		}
+		lines = append(lines, fmt.Sprintf("        %s[\"%s\"]", node.ID, node.Label))
+		externalIDs = append(externalIDs, node.ID)
+	}
+	if len(externalIDs) > 0 || module != "" {
+		lines = append(lines, "    end")
+	}
+
+	for _, edge := range graph.Edges {
+		lines = append(lines, fmt.Sprintf("    %s --> %s", edge[0], edge[1]))
+	}
+
+	if len(externalIDs) > 0 {
+		lines = append(lines,
+			"    classDef externalDep stroke-dasharray: 5 5",
+			"    class "+strings.Join(externalIDs, ",")+" externalDep")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// packageLabel returns the import path of a package relative to its module
+func packageLabel(projectData *parser.RawProjectData, pkg *parser.Package) string {
+	module := projectData.ModuleByPath(pkg.Module)
+	if module == nil {
+		module = projectData.Module
+	}
+	if module == nil {
+		return pkg.ImportPath
+	}
+	if rel := strings.TrimPrefix(pkg.ImportPath, module.Path+"/"); rel != pkg.ImportPath {
+		return rel
+	}
+	return pkg.ImportPath
+}
@@ -0,0 +1,140 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrMissingAPIKey is returned by NewProvider when the selected provider
+// needs an API key and none was configured
+var ErrMissingAPIKey = errors.New("missing API key")
+
+// Provider is an LLM backend that can turn a prompt into a completion
+type Provider interface {
+	// Name returns the provider identifier, e.g. "anthropic"
+	Name() string
+
+	// Model returns the model used for completions
+	Model() string
+
+	// Complete sends a system and user prompt and returns the text response
+	Complete(ctx context.Context, req CompletionRequest) (*CompletionResponse, error)
+}
+
+// CompletionRequest is a single prompt sent to a Provider
+type CompletionRequest struct {
+	System    string
+	Prompt    string
+	MaxTokens int // Zero uses the provider's default
+}
+
+// CompletionResponse is the text returned by a Provider
+type CompletionResponse struct {
+	Text   string
+	Header http.Header // Response headers, nil if the backend does not expose them
+}
+
+// ProviderConfig selects and configures a Provider
+type ProviderConfig struct {
+	Name    string // anthropic, gemini or openai
+	Model   string // Empty selects the provider's default model
+	APIKey  string // Empty reads the provider's usual environment variable
+	BaseURL string // Overrides the API endpoint, e.g. for local OpenAI-compatible servers
+}
+
+// APIError is returned by providers when the API responds with a non-success status
+type APIError struct {
+	StatusCode int
+	Body       string
+	Header     http.Header
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error status %d: %s", e.StatusCode, e.Body)
+}
+
+// NewProvider creates the Provider selected by cfg
+func NewProvider(cfg ProviderConfig) (Provider, error) {
+	switch strings.ToLower(cfg.Name) {
+	case "", "anthropic":
+		provider, err := newAnthropicProvider(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	case "gemini", "google":
+		provider, err := newGeminiProvider(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	case "openai":
+		provider, err := newOpenAIProvider(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q (expected anthropic, gemini or openai)", cfg.Name)
+}
+
+// firstEnv returns the value of the first environment variable that is set
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// httpClient is shared by the HTTP based providers. It has no timeout of its
+// own because completions with long thinking budgets can take many minutes;
+// requests are bounded by their context instead.
+var httpClient = &http.Client{}
+
+// postJSON sends a JSON request and returns the response body and headers,
+// or an *APIError if the API responded with a non-success status
+func postJSON(ctx context.Context, url string, headers map[string]string, body interface{}) ([]byte, http.Header, error) {
+	requestJSON, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestJSON))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("API request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.Header, fmt.Errorf("error reading response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, resp.Header, &APIError{
+			StatusCode: resp.StatusCode,
+			Body:       string(responseBody),
+			Header:     resp.Header,
+		}
+	}
+
+	return responseBody, resp.Header, nil
+}
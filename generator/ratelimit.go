@@ -0,0 +1,155 @@
+package generator
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRequestInterval is the average time between API calls of the
+// default rate limiter
+const DefaultRequestInterval = 3 * time.Second
+
+// RateLimiter is a token bucket shared by all API calls of a run. It is
+// safe for concurrent use. Besides the fixed rate, it pauses all calls when
+// a provider reports through its response headers that a limit is exhausted.
+type RateLimiter struct {
+	mu           sync.Mutex
+	interval     time.Duration // Time to refill one token, zero for no limit
+	burst        float64
+	tokens       float64
+	last         time.Time // Last refill
+	blockedUntil time.Time // Set from rate limit headers and backoffs
+
+	// Logger receives the waits, nil uses slog's default logger
+	Logger *slog.Logger
+
+	now func() time.Time
+}
+
+// NewRateLimiter returns a limiter that allows one call per interval on
+// average and up to burst calls at once. An interval of zero disables the
+// fixed rate, leaving only the pauses requested by providers.
+func NewRateLimiter(interval time.Duration, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		now:      time.Now,
+	}
+}
+
+// Wait blocks until a call may be made and takes a token for it
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		delay := l.reserve()
+		if delay <= 0 {
+			return nil
+		}
+		logger := l.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.Info("Rate limiting: waiting before next API call", "delay", delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token if one is available, or returns how long to wait
+// before trying again
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Before(l.blockedUntil) {
+		return l.blockedUntil.Sub(now)
+	}
+	if l.interval <= 0 {
+		return 0
+	}
+
+	if !l.last.IsZero() {
+		l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) * float64(l.interval))
+}
+
+// Backoff pauses all calls for at least d
+func (l *RateLimiter) Backoff(d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := l.now().Add(d); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+// Update pauses calls as requested by the rate limit headers of a response.
+// It reports whether the headers asked for a pause.
+//
+// Retry-After is honored in both of its forms, seconds and HTTP date.
+// Anthropic's anthropic-ratelimit-<limit>-remaining headers pause calls until
+// the matching anthropic-ratelimit-<limit>-reset time when they reach zero.
+func (l *RateLimiter) Update(header http.Header) bool {
+	if l == nil || header == nil {
+		return false
+	}
+	now := l.now()
+	var pause time.Duration
+
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			pause = max(pause, time.Duration(seconds)*time.Second)
+		} else if date, err := http.ParseTime(value); err == nil {
+			pause = max(pause, date.Sub(now))
+		}
+	}
+
+	const prefix, remaining, reset = "Anthropic-Ratelimit-", "-Remaining", "-Reset"
+	for key, values := range header {
+		if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, remaining) || len(values) == 0 {
+			continue
+		}
+		if count, err := strconv.Atoi(values[0]); err != nil || count > 0 {
+			continue
+		}
+		resetKey := strings.TrimSuffix(key, remaining) + reset
+		if resetTime, err := time.Parse(time.RFC3339, header.Get(resetKey)); err == nil {
+			pause = max(pause, resetTime.Sub(now))
+		}
+	}
+
+	if pause <= 0 {
+		return false
+	}
+	l.Backoff(pause)
+	return true
+}
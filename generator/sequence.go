@@ -0,0 +1,194 @@
+package generator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Nurozen/mermgen/parser"
+)
+
+const (
+	// defaultSequenceDepth is how many levels of calls are followed from the entry function
+	defaultSequenceDepth = 3
+
+	// maxSequenceMessages keeps generated sequence diagrams renderable
+	maxSequenceMessages = 300
+)
+
+// sequenceParticipant is a package or type taking part in a sequence diagram
+type sequenceParticipant struct {
+	id    string
+	label string
+}
+
+// sequenceBuilder accumulates the participants and messages of a sequence diagram
+type sequenceBuilder struct {
+	projectData  *parser.RawProjectData
+	graph        *parser.CallGraph
+	externalDeps ExternalDeps
+
+	participants []sequenceParticipant
+	byKey        map[string]string // Participant key -> id
+	usedIDs      map[string]bool
+	lines        []string
+	messages     int
+}
+
+// renderSequenceDiagram builds a Mermaid sequence diagram by following the
+// static call graph from an entry function up to depth levels of calls.
+// Without an entry, main.main is used, or else the function reaching the
+// most other functions of the project.
+func renderSequenceDiagram(projectData *parser.RawProjectData, entry string, depth int, externalDeps ExternalDeps) (string, error) {
+	if depth <= 0 {
+		depth = defaultSequenceDepth
+	}
+	if externalDeps == "" {
+		externalDeps = ExternalDepsCollapse
+	}
+
+	graph := projectData.CallGraph()
+	start, err := findEntryFunc(graph, entry, depth)
+	if err != nil {
+		return "", err
+	}
+
+	builder := &sequenceBuilder{
+		projectData:  projectData,
+		graph:        graph,
+		externalDeps: externalDeps,
+		byKey:        make(map[string]string),
+		usedIDs:      make(map[string]bool),
+	}
+	startID := builder.participant(funcParticipantKey(start.PackagePath, start.Receiver), funcParticipantLabel(start.PackageName, start.Receiver))
+	builder.lines = append(builder.lines, fmt.Sprintf("    Note over %s: %s()", startID, start.Name))
+	builder.visit(start, startID, depth, map[string]bool{start.ID: true})
+
+	lines := []string{"sequenceDiagram"}
+	for _, participant := range builder.participants {
+		lines = append(lines, fmt.Sprintf("    participant %s as %s", participant.id, participant.label))
+	}
+	lines = append(lines, builder.lines...)
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// visit emits the calls made by node and recurses into project functions
+func (b *sequenceBuilder) visit(node *parser.FuncNode, callerID string, depth int, onStack map[string]bool) {
+	for _, call := range node.Calls {
+		if b.messages >= maxSequenceMessages {
+			if b.messages == maxSequenceMessages {
+				b.lines = append(b.lines, fmt.Sprintf("    Note over %s: further calls omitted", callerID))
+				b.messages++
+			}
+			return
+		}
+
+		var calleeID string
+		callee := b.graph.Funcs[call.Callee]
+		switch {
+		case call.Kind == parser.ImportInternal && callee != nil:
+			calleeID = b.participant(funcParticipantKey(callee.PackagePath, callee.Receiver), funcParticipantLabel(callee.PackageName, callee.Receiver))
+		case b.externalDeps == ExternalDepsFull:
+			calleeID = b.participant("ext\x00"+call.PackagePath, call.PackagePath)
+		case b.externalDeps == ExternalDepsCollapse && call.Kind == parser.ImportExternal:
+			module := b.projectData.ExternalModule(call.PackagePath)
+			calleeID = b.participant("ext\x00"+module, module)
+		default:
+			continue
+		}
+
+		b.lines = append(b.lines, fmt.Sprintf("    %s->>%s: %s()", callerID, calleeID, call.Name))
+		b.messages++
+
+		// Follow project functions until the depth is exhausted, without recursing into cycles
+		if callee == nil || depth <= 1 || onStack[callee.ID] || len(callee.Calls) == 0 {
+			continue
+		}
+		onStack[callee.ID] = true
+		b.lines = append(b.lines, "    activate "+calleeID)
+		b.visit(callee, calleeID, depth-1, onStack)
+		b.lines = append(b.lines, "    deactivate "+calleeID)
+		delete(onStack, callee.ID)
+	}
+}
+
+// participant returns the id of the participant for key, declaring it on first use
+func (b *sequenceBuilder) participant(key, label string) string {
+	if id, ok := b.byKey[key]; ok {
+		return id
+	}
+
+	base := mermaidID(label)
+	id := base
+	for i := 2; b.usedIDs[id]; i++ {
+		id = fmt.Sprintf("%s_%d", base, i)
+	}
+
+	b.byKey[key] = id
+	b.usedIDs[id] = true
+	b.participants = append(b.participants, sequenceParticipant{id: id, label: label})
+	return id
+}
+
+// findEntryFunc resolves the entry function of a sequence diagram
+func findEntryFunc(graph *parser.CallGraph, entry string, depth int) (*parser.FuncNode, error) {
+	if entry != "" {
+		node, err := graph.FindFunc(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sequence diagram entry: %w", err)
+		}
+		return node, nil
+	}
+
+	if node, err := graph.FindFunc("main.main"); err == nil {
+		return node, nil
+	}
+
+	ids := make([]string, 0, len(graph.Funcs))
+	for id := range graph.Funcs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var best *parser.FuncNode
+	bestReach := 0
+	for _, id := range ids {
+		reach := len(reachableFuncs(graph, graph.Funcs[id], depth, make(map[string]bool)))
+		if reach > bestReach {
+			best = graph.Funcs[id]
+			bestReach = reach
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no function with calls to other project functions found for the sequence diagram")
+	}
+	return best, nil
+}
+
+// reachableFuncs collects the project functions reachable from node within depth calls
+func reachableFuncs(graph *parser.CallGraph, node *parser.FuncNode, depth int, seen map[string]bool) map[string]bool {
+	if depth <= 0 {
+		return seen
+	}
+	for _, call := range node.Calls {
+		callee := graph.Funcs[call.Callee]
+		if callee == nil || seen[callee.ID] {
+			continue
+		}
+		seen[callee.ID] = true
+		reachableFuncs(graph, callee, depth-1, seen)
+	}
+	return seen
+}
+
+func funcParticipantKey(pkgPath, receiver string) string {
+	return pkgPath + "\x00" + receiver
+}
+
+func funcParticipantLabel(pkgName, receiver string) string {
+	if receiver == "" {
+		return pkgName
+	}
+	return pkgName + "." + receiver
+}
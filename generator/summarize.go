@@ -0,0 +1,400 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/Nurozen/mermgen/parser"
+)
+
+// TokenBudget limits how much is sent to and requested from the AI provider
+// at each stage. Token counts are estimated at four bytes per token.
+type TokenBudget struct {
+	// PromptTokens caps the project data in a single prompt. Projects whose
+	// sources do not fit are summarized package by package first, and
+	// packages that do not fit are summarized in chunks.
+	PromptTokens int
+
+	// SummaryTokens caps the response of each package summary
+	SummaryTokens int
+
+	// DiagramTokens caps the response of the final diagram. Zero uses the
+	// provider's default.
+	DiagramTokens int
+}
+
+// DefaultTokenBudget is used for every TokenBudget field left at zero,
+// except DiagramTokens
+var DefaultTokenBudget = TokenBudget{
+	PromptTokens:  100000,
+	SummaryTokens: 4096,
+}
+
+// maxCondenseRounds bounds how often summaries that do not fit a prompt
+// are merged into fewer, shorter summaries
+const maxCondenseRounds = 3
+
+// withDefaults fills zero fields from DefaultTokenBudget
+func (b TokenBudget) withDefaults() TokenBudget {
+	if b.PromptTokens <= 0 {
+		b.PromptTokens = DefaultTokenBudget.PromptTokens
+	}
+	if b.SummaryTokens <= 0 {
+		b.SummaryTokens = DefaultTokenBudget.SummaryTokens
+	}
+	return b
+}
+
+// PackageSummary is the compact description of a package produced by the
+// map stage and consumed by the diagram prompts
+type PackageSummary struct {
+	Package      string           `json:"package"`
+	Purpose      string           `json:"purpose"`
+	Types        []SummaryType    `json:"types,omitempty"`
+	Functions    []string         `json:"functions,omitempty"`    // Key exported functions with simplified signatures
+	Interactions []string         `json:"interactions,omitempty"` // Calls into other packages, e.g. "Handler.Serve -> store.DB.Get"
+	Parts        []PackageSummary `json:"parts,omitempty"`        // Summaries of the chunks of a package too large for one prompt
+}
+
+// SummaryType is a type as described by a package summary
+type SummaryType struct {
+	Name          string   `json:"name"`
+	Kind          string   `json:"kind"`
+	Fields        []string `json:"fields,omitempty"`
+	Methods       []string `json:"methods,omitempty"`
+	Relationships []string `json:"relationships,omitempty"` // e.g. "embeds Base", "implements io.Reader", "has *store.DB"
+}
+
+// summaryStore computes the package summaries once per GenerateDiagrams call
+// and shares them between the diagrams that need them
+type summaryStore struct {
+	once      sync.Once
+	summaries []PackageSummary
+	degraded  error // *SummaryError if some summaries fell back to symbols
+	err       error
+}
+
+// estimateTokens approximates the number of tokens in text
+func estimateTokens(text string) int {
+	return len(text)/4 + 1
+}
+
+// fileEntry describes a file for a prompt. Symbols spare the model from
+// recovering declarations from the source text.
+func fileEntry(path string, fileData *parser.FileData, withSymbols bool) map[string]interface{} {
+	entry := map[string]interface{}{
+		"path":        path,
+		"packageName": fileData.PackageName,
+		"content":     fileData.Content,
+	}
+	if withSymbols {
+		entry["types"] = fileData.Types
+		entry["functions"] = fileData.Functions
+		entry["methods"] = fileData.Methods
+	}
+	return entry
+}
+
+// projectContext returns the project data for the class and sequence
+// prompts: every Go file when the sources fit the prompt budget, otherwise
+// the package summaries. The key names the data for the model. degraded is
+// a *SummaryError if some of the summaries fell back to parsed symbols.
+func projectContext(ctx context.Context, projectData *parser.RawProjectData, opts Options, withSymbols bool) (key string, data interface{}, degraded error, err error) {
+	budget := opts.Budget.withDefaults()
+
+	paths := make([]string, 0, len(projectData.Files))
+	for path := range projectData.Files {
+		if strings.HasSuffix(path, ".go") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	fileInfo := make([]map[string]interface{}, 0, len(paths))
+	for _, path := range paths {
+		fileInfo = append(fileInfo, fileEntry(path, projectData.Files[path], withSymbols))
+	}
+	fileJSON, err := json.Marshal(fileInfo)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("error marshaling fileInfo: %w", err)
+	}
+	if estimateTokens(string(fileJSON)) <= budget.PromptTokens {
+		return "fileInfo", string(fileJSON), nil, nil
+	}
+
+	store := opts.summaries
+	if store == nil {
+		store = &summaryStore{}
+	}
+	store.once.Do(func() {
+		opts.Budget = budget
+		var failures []error
+		store.summaries, failures, store.err = summarizeProject(ctx, projectData, opts)
+		if len(failures) > 0 {
+			store.degraded = &SummaryError{Errs: failures}
+		}
+	})
+	return "packageSummaries", store.summaries, store.degraded, store.err
+}
+
+// summarizeProject runs the map stage over every package of the project and
+// condenses the results until they fit into a single prompt. It also returns
+// the failed summary and condense calls, whose input was kept as parsed
+// symbols. opts.Budget must have its defaults applied.
+func summarizeProject(ctx context.Context, projectData *parser.RawProjectData, opts Options) ([]PackageSummary, []error, error) {
+	budget := opts.Budget
+	importPaths := make([]string, 0, len(projectData.Packages))
+	for importPath := range projectData.Packages {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+
+	opts.logger().Info("Project exceeds the prompt budget, summarizing packages", "packages", len(importPaths))
+	summaries := make([]PackageSummary, 0, len(importPaths))
+	var failures []error
+	for _, importPath := range importPaths {
+		summary, errs := summarizePackage(ctx, projectData, projectData.Packages[importPath], opts)
+		summaries = append(summaries, summary)
+		failures = append(failures, errs...)
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for round := 0; ; round++ {
+		summaryJSON, err := json.Marshal(summaries)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error marshaling package summaries: %w", err)
+		}
+		if estimateTokens(string(summaryJSON)) <= budget.PromptTokens || len(summaries) == 1 {
+			return summaries, failures, nil
+		}
+		if round == maxCondenseRounds {
+			return nil, nil, fmt.Errorf("package summaries still exceed the prompt budget of %d tokens after %d rounds", budget.PromptTokens, maxCondenseRounds)
+		}
+		var errs []error
+		summaries, errs = condenseSummaries(ctx, summaries, opts)
+		failures = append(failures, errs...)
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// summarizePackage asks the provider for a summary of one package, splitting
+// its files into chunks that fit the prompt budget. Chunks whose summary
+// fails are described by their parsed symbols instead, and the failures are
+// returned.
+func summarizePackage(ctx context.Context, projectData *parser.RawProjectData, pkg *parser.Package, opts Options) (PackageSummary, []error) {
+	budget := opts.Budget
+	var chunks [][]map[string]interface{}
+	var chunk []map[string]interface{}
+	chunkTokens := 0
+	for _, path := range pkg.Files {
+		entry := fileEntry(path, projectData.Files[path], false)
+		tokens := estimateTokens(projectData.Files[path].Content)
+		if tokens > budget.PromptTokens {
+			// A single file larger than the budget is cut down to fit
+			entry["content"] = truncateUTF8(projectData.Files[path].Content, budget.PromptTokens*4) + "... [truncated]"
+			tokens = budget.PromptTokens
+		}
+		if len(chunk) > 0 && chunkTokens+tokens > budget.PromptTokens {
+			chunks = append(chunks, chunk)
+			chunk, chunkTokens = nil, 0
+		}
+		chunk = append(chunk, entry)
+		chunkTokens += tokens
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	var parts []PackageSummary
+	var failures []error
+	for i, files := range chunks {
+		opts.logger().Info("Summarizing package", "package", pkg.ImportPath, "part", i+1, "of", len(chunks))
+		summary, err := requestSummary(ctx, opts, pkg.ImportPath, files)
+		if err != nil {
+			opts.logger().Warn("Package summary failed, using parsed symbols", "package", pkg.ImportPath, "error", err)
+			failures = append(failures, fmt.Errorf("summary of %s: %w", pkg.ImportPath, err))
+			summary = symbolSummary(projectData, pkg, files)
+		}
+		parts = append(parts, summary)
+	}
+
+	if len(parts) == 1 {
+		return parts[0], failures
+	}
+	return PackageSummary{Package: pkg.ImportPath, Parts: parts}, failures
+}
+
+// truncateUTF8 cuts text to at most n bytes without splitting a rune
+func truncateUTF8(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
+
+// requestSummary runs the map stage prompt for a chunk of a package
+func requestSummary(ctx context.Context, opts Options, importPath string, files []map[string]interface{}) (PackageSummary, error) {
+	filesJSON, err := json.Marshal(files)
+	if err != nil {
+		return PackageSummary{}, fmt.Errorf("error marshaling files: %w", err)
+	}
+
+	request := CompletionRequest{
+		System: "You are an expert in Go programming. Summarize Go packages into compact JSON that is later used to draw " +
+			"class and sequence diagrams of the whole repository. Only return the JSON object, nothing else.",
+		Prompt: fmt.Sprintf("Summarize the Go package %s from the following files:\n\n%s\n\n"+
+			"Return a JSON object with these keys: \"package\" (the import path), \"purpose\" (one sentence), "+
+			"\"types\" (list of {\"name\", \"kind\", \"fields\", \"methods\", \"relationships\"}, with simplified signatures "+
+			"and relationships such as \"embeds Base\", \"implements io.Reader\" or \"has *store.DB\"), "+
+			"\"functions\" (key exported functions with simplified signatures) and "+
+			"\"interactions\" (important calls into other packages, written as \"Caller -> pkg.Callee\"). "+
+			"Leave out unexported helpers unless they are central to the package.",
+			importPath, string(filesJSON)),
+		MaxTokens: opts.Budget.SummaryTokens,
+	}
+
+	var summary PackageSummary
+	if err := completeJSON(ctx, opts, request, &summary); err != nil {
+		return PackageSummary{}, err
+	}
+	summary.Package = importPath
+	return summary, nil
+}
+
+// completeJSON requests a JSON object and decodes it into v. Like diagrams,
+// responses are only cached once they decode, and cached responses that do
+// not decode are requested again.
+func completeJSON(ctx context.Context, opts Options, request CompletionRequest, v interface{}) error {
+	if text, ok := opts.Cache.lookup(opts.Provider, request); ok {
+		if json.Unmarshal([]byte(extractJSON(text)), v) == nil {
+			return nil
+		}
+	}
+
+	content, err := complete(ctx, opts, request)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(extractJSON(content)), v); err != nil {
+		return fmt.Errorf("error parsing response: %w", err)
+	}
+	opts.Cache.store(opts.Provider, request, content, opts.logger())
+	return nil
+}
+
+// condenseSummaries is the extra reduce step for repositories whose package
+// summaries do not fit a single prompt: neighbouring summaries are merged
+// into one shorter summary per batch. Batches that fail to merge keep their
+// types and interactions, and the failures are returned.
+func condenseSummaries(ctx context.Context, summaries []PackageSummary, opts Options) ([]PackageSummary, []error) {
+	budget := opts.Budget
+	var batches [][]PackageSummary
+	var batch []PackageSummary
+	batchTokens := 0
+	for _, summary := range summaries {
+		summaryJSON, _ := json.Marshal(summary)
+		tokens := estimateTokens(string(summaryJSON))
+		if len(batch) > 0 && batchTokens+tokens > budget.PromptTokens {
+			batches = append(batches, batch)
+			batch, batchTokens = nil, 0
+		}
+		batch = append(batch, summary)
+		batchTokens += tokens
+	}
+	batches = append(batches, batch)
+
+	// Merging pairs at least halves the count when every summary fills a batch on its own
+	if len(batches) == len(summaries) {
+		batches = batches[:0]
+		for i := 0; i < len(summaries); i += 2 {
+			batches = append(batches, summaries[i:min(i+2, len(summaries))])
+		}
+	}
+
+	condensed := make([]PackageSummary, 0, len(batches))
+	var failures []error
+	for i, batch := range batches {
+		opts.logger().Info("Condensing package summaries", "batch", i+1, "of", len(batches))
+		batchJSON, _ := json.Marshal(batch)
+		names := make([]string, len(batch))
+		for j, summary := range batch {
+			names[j] = summary.Package
+		}
+
+		request := CompletionRequest{
+			System: "You are an expert in Go programming. Merge summaries of Go packages into one shorter JSON summary " +
+				"that keeps the most important types, relationships and cross-package interactions. Only return the JSON object, nothing else.",
+			Prompt:    fmt.Sprintf("Merge these package summaries into a single JSON object with the same keys:\n\n%s", string(batchJSON)),
+			MaxTokens: budget.SummaryTokens,
+		}
+		summary := PackageSummary{Package: strings.Join(names, ", ")}
+		if err := completeJSON(ctx, opts, request, &summary); err != nil {
+			// Keep the batch, dropping the least important detail
+			opts.logger().Warn("Condensing summaries failed, dropping details", "error", err)
+			failures = append(failures, fmt.Errorf("condensing %s: %w", strings.Join(names, ", "), err))
+			for _, part := range batch {
+				summary.Types = append(summary.Types, part.Types...)
+				summary.Interactions = append(summary.Interactions, part.Interactions...)
+			}
+		}
+		summary.Package = strings.Join(names, ", ")
+		condensed = append(condensed, summary)
+	}
+	return condensed, failures
+}
+
+// symbolSummary describes a chunk of a package from its parsed symbols
+func symbolSummary(projectData *parser.RawProjectData, pkg *parser.Package, files []map[string]interface{}) PackageSummary {
+	summary := PackageSummary{Package: pkg.ImportPath, Purpose: "Package " + pkg.Name}
+	for _, file := range files {
+		fileData := projectData.Files[file["path"].(string)]
+		for _, decl := range fileData.Types {
+			summaryType := SummaryType{Name: decl.Name, Kind: string(decl.Kind)}
+			for _, field := range decl.Fields {
+				if field.Embedded {
+					summaryType.Relationships = append(summaryType.Relationships, "embeds "+field.Type)
+					continue
+				}
+				summaryType.Fields = append(summaryType.Fields, field.Name+" "+field.Type)
+			}
+			for _, method := range decl.Methods {
+				summaryType.Methods = append(summaryType.Methods, method.Name)
+			}
+			summary.Types = append(summary.Types, summaryType)
+		}
+		for _, fn := range fileData.Functions {
+			summary.Functions = append(summary.Functions, fn.Name)
+		}
+		for _, method := range fileData.Methods {
+			for i := range summary.Types {
+				if summary.Types[i].Name == method.Receiver.Type {
+					summary.Types[i].Methods = append(summary.Types[i].Methods, method.Name)
+				}
+			}
+		}
+	}
+	return summary
+}
+
+// extractJSON returns the JSON object in a response, which models
+// sometimes wrap in a code fence or surrounding prose
+func extractJSON(content string) string {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start == -1 || end < start {
+		return content
+	}
+	return content[start : end+1]
+}
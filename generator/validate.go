@@ -0,0 +1,339 @@
+package generator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MermaidError is a syntax error found in a Mermaid diagram
+type MermaidError struct {
+	Line    int // 1-based line number within the diagram code
+	Message string
+}
+
+func (e MermaidError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// mermaidLine is a non-empty, non-comment line of a diagram
+type mermaidLine struct {
+	number int
+	text   string // Trimmed line content
+}
+
+var (
+	classRelationPattern   = regexp.MustCompile(`^[\w.~]+\s*(?:"[^"]*"\s*)?(?:<\||\*|o|<)?(?:--|\.\.)(?:\|>|\*|o|>)?\s*(?:"[^"]*"\s*)?[\w.~]+\s*(?::.*)?$`)
+	classDeclPattern       = regexp.MustCompile(`^class\s+[\w.]+(?:~[^{]*~)?(?:\["[^"]*"\])?(?::::\w+)?\s*(\{)?\s*$`)
+	classMemberPattern     = regexp.MustCompile(`^[\w~]+\s*:\s*\S.*$`)
+	namespacePattern       = regexp.MustCompile(`^namespace\s+[\w.]+\s*\{\s*$`)
+	annotationPattern      = regexp.MustCompile(`^<<[^<>]+>>(?:\s+[\w~]+)?$`)
+	directionPattern       = regexp.MustCompile(`^direction\s+(?:TB|TD|BT|RL|LR)$`)
+	flowchartHeaderPattern = regexp.MustCompile(`^(?:flowchart|graph)(?:\s+(?:TB|TD|BT|RL|LR))?\s*;?$`)
+	sequenceMessagePattern = regexp.MustCompile(`^(.+?)\s*(<<-->>|<<->>|-->>|->>|-->|->|--x|-x|--\)|-\))\s*([+-]?)\s*(.+?)\s*:(.*)$`)
+	participantPattern     = regexp.MustCompile(`^(?:create\s+)?(?:participant|actor)\s+(\S+)(?:\s+as\s+.+)?$`)
+	notePattern            = regexp.MustCompile(`(?i)^note\s+(?:left of|right of|over)\s+[^:]+:.*$`)
+	blockStartPattern      = regexp.MustCompile(`^(?:loop|alt|opt|par|critical|break|rect|box)\b`)
+	blockBranchPattern     = regexp.MustCompile(`^(?:else|and|option)\b`)
+)
+
+// styleKeywords start styling statements that every diagram type accepts
+var styleKeywords = []string{"classDef ", "style ", "linkStyle ", "click ", "accTitle", "accDescr", "title "}
+
+// ValidateMermaid checks a Mermaid diagram for syntax errors. It covers the
+// subsets mermgen emits: class diagrams, flowcharts and sequence diagrams.
+// Other diagram types are reported as unsupported.
+func ValidateMermaid(code string) []MermaidError {
+	var lines []mermaidLine
+	for i, line := range strings.Split(code, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "%%") {
+			continue
+		}
+		lines = append(lines, mermaidLine{number: i + 1, text: line})
+	}
+	if len(lines) == 0 {
+		return []MermaidError{{Line: 1, Message: "diagram is empty"}}
+	}
+
+	header, body := lines[0], lines[1:]
+	switch {
+	case header.text == "classDiagram" || header.text == "classDiagram-v2":
+		return validateClassDiagram(body)
+	case flowchartHeaderPattern.MatchString(header.text):
+		return validateFlowchart(body)
+	case header.text == "sequenceDiagram":
+		return validateSequenceDiagram(body)
+	}
+	return []MermaidError{{Line: header.number, Message: fmt.Sprintf("unsupported diagram type %q", header.text)}}
+}
+
+// mermaidDiagramType returns the diagram keyword of a Mermaid diagram,
+// normalizing "graph" to "flowchart"
+func mermaidDiagramType(code string) string {
+	for _, line := range strings.Split(code, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "%%") {
+			continue
+		}
+		keyword := strings.Fields(line)[0]
+		switch keyword {
+		case "graph":
+			return "flowchart"
+		case "classDiagram-v2":
+			return "classDiagram"
+		}
+		return keyword
+	}
+	return ""
+}
+
+// validateDiagram checks that code is a valid Mermaid diagram of the type
+// mermgen expects for diagramType
+func validateDiagram(diagramType, code string) []MermaidError {
+	expected := map[string]string{
+		"class":    "classDiagram",
+		"package":  "flowchart",
+		"sequence": "sequenceDiagram",
+	}[diagramType]
+	if actual := mermaidDiagramType(code); expected != "" && actual != expected {
+		return []MermaidError{{Line: 1, Message: fmt.Sprintf("expected a %s diagram, got %q", expected, actual)}}
+	}
+	return ValidateMermaid(code)
+}
+
+func validateClassDiagram(lines []mermaidLine) []MermaidError {
+	var errs []MermaidError
+	var blocks []mermaidLine // Open class and namespace blocks
+	inClass := false
+
+	for _, line := range lines {
+		text := line.text
+
+		if inClass {
+			switch {
+			case text == "}":
+				blocks = blocks[:len(blocks)-1]
+				inClass = false
+			case strings.Contains(text, "{") || strings.Contains(text, "}"):
+				errs = append(errs, MermaidError{line.number, "braces are not allowed in class members"})
+			case !balanced(text, "()"):
+				errs = append(errs, MermaidError{line.number, "unbalanced parentheses in class member"})
+			}
+			continue
+		}
+
+		switch {
+		case text == "}":
+			if len(blocks) == 0 {
+				errs = append(errs, MermaidError{line.number, "unexpected \"}\" without an open block"})
+			} else {
+				blocks = blocks[:len(blocks)-1]
+			}
+		case strings.HasPrefix(text, "class "):
+			match := classDeclPattern.FindStringSubmatch(text)
+			if match == nil {
+				errs = append(errs, MermaidError{line.number, "invalid class declaration"})
+			} else if match[1] != "" {
+				blocks = append(blocks, line)
+				inClass = true
+			}
+		case strings.HasPrefix(text, "namespace "):
+			if !namespacePattern.MatchString(text) {
+				errs = append(errs, MermaidError{line.number, "invalid namespace declaration"})
+			} else {
+				blocks = append(blocks, line)
+			}
+		case strings.HasPrefix(text, "note"), strings.HasPrefix(text, "cssClass "),
+			strings.HasPrefix(text, "link "), strings.HasPrefix(text, "callback "),
+			hasStyleKeyword(text), directionPattern.MatchString(text), annotationPattern.MatchString(text):
+		case classRelationPattern.MatchString(text):
+		case classMemberPattern.MatchString(text):
+			if !balanced(text, "()") {
+				errs = append(errs, MermaidError{line.number, "unbalanced parentheses in class member"})
+			}
+		default:
+			errs = append(errs, MermaidError{line.number, fmt.Sprintf("unrecognized statement %q", text)})
+		}
+	}
+
+	for _, block := range blocks {
+		errs = append(errs, MermaidError{block.number, "block is never closed with \"}\""})
+	}
+	return errs
+}
+
+func validateFlowchart(lines []mermaidLine) []MermaidError {
+	var errs []MermaidError
+	var subgraphs []mermaidLine
+
+	for _, line := range lines {
+		text := strings.TrimSuffix(line.text, ";")
+
+		switch {
+		case text == "":
+		case text == "end":
+			if len(subgraphs) == 0 {
+				errs = append(errs, MermaidError{line.number, "\"end\" without an open subgraph"})
+			} else {
+				subgraphs = subgraphs[:len(subgraphs)-1]
+			}
+		case text == "subgraph" || strings.HasPrefix(text, "subgraph "):
+			if strings.TrimSpace(strings.TrimPrefix(text, "subgraph")) == "" {
+				errs = append(errs, MermaidError{line.number, "subgraph needs an id"})
+			}
+			subgraphs = append(subgraphs, line)
+		case strings.HasPrefix(text, "class "), hasStyleKeyword(text), directionPattern.MatchString(text):
+		default:
+			if !isIdentByte(text[0]) {
+				errs = append(errs, MermaidError{line.number, fmt.Sprintf("statement must start with a node id: %q", text)})
+			} else if msg := checkFlowchartBrackets(text); msg != "" {
+				errs = append(errs, MermaidError{line.number, msg})
+			}
+		}
+	}
+
+	for _, subgraph := range subgraphs {
+		errs = append(errs, MermaidError{subgraph.number, "subgraph is never closed with \"end\""})
+	}
+	return errs
+}
+
+// checkFlowchartBrackets verifies that the node shapes of a flowchart
+// statement are balanced, ignoring quoted text and edge labels
+func checkFlowchartBrackets(text string) string {
+	closers := map[byte]byte{'[': ']', '(': ')', '{': '}'}
+	var stack []byte
+	inQuote, inLabel := false, false
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '|' && len(stack) == 0:
+			inLabel = !inLabel
+		case inLabel:
+		case c == '[' || c == '(' || c == '{':
+			stack = append(stack, closers[c])
+		// Asymmetric shapes such as id>label] open with '>' directly after the id
+		case c == '>' && len(stack) == 0 && i > 0 && isIdentByte(text[i-1]):
+			stack = append(stack, ']')
+		case c == ']' || c == ')' || c == '}':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return fmt.Sprintf("unexpected %q in node shape", c)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	switch {
+	case inQuote:
+		return "unterminated quoted text"
+	case inLabel:
+		return "unterminated edge label"
+	case len(stack) > 0:
+		return fmt.Sprintf("missing %q in node shape", stack[len(stack)-1])
+	}
+	return ""
+}
+
+func validateSequenceDiagram(lines []mermaidLine) []MermaidError {
+	var errs []MermaidError
+	var blocks []mermaidLine
+	active := make(map[string]int)
+
+	deactivate := func(line mermaidLine, participant string) {
+		if active[participant] == 0 {
+			errs = append(errs, MermaidError{line.number, fmt.Sprintf("%s is deactivated but not active", participant)})
+			return
+		}
+		active[participant]--
+	}
+
+	for _, line := range lines {
+		text := line.text
+
+		switch {
+		case text == "end":
+			if len(blocks) == 0 {
+				errs = append(errs, MermaidError{line.number, "\"end\" without an open block"})
+			} else {
+				blocks = blocks[:len(blocks)-1]
+			}
+		case blockStartPattern.MatchString(text):
+			blocks = append(blocks, line)
+		case blockBranchPattern.MatchString(text):
+			if len(blocks) == 0 {
+				errs = append(errs, MermaidError{line.number, fmt.Sprintf("%q outside of a block", strings.Fields(text)[0])})
+			}
+		case strings.HasPrefix(text, "participant "), strings.HasPrefix(text, "actor "), strings.HasPrefix(text, "create "):
+			if !participantPattern.MatchString(text) {
+				errs = append(errs, MermaidError{line.number, "invalid participant declaration"})
+			}
+		case strings.HasPrefix(text, "activate "):
+			active[strings.TrimSpace(strings.TrimPrefix(text, "activate "))]++
+		case strings.HasPrefix(text, "deactivate "):
+			deactivate(line, strings.TrimSpace(strings.TrimPrefix(text, "deactivate ")))
+		case strings.HasPrefix(text, "destroy "), text == "autonumber", strings.HasPrefix(text, "autonumber "), hasStyleKeyword(text):
+		case strings.HasPrefix(strings.ToLower(text), "note "):
+			if !notePattern.MatchString(text) {
+				errs = append(errs, MermaidError{line.number, "invalid note, expected \"Note left of|right of|over <participant>: text\""})
+			}
+		default:
+			match := sequenceMessagePattern.FindStringSubmatch(text)
+			if match == nil {
+				errs = append(errs, MermaidError{line.number, fmt.Sprintf("unrecognized statement %q", text)})
+				continue
+			}
+			// "+" activates the receiver, "-" deactivates the sender
+			switch match[3] {
+			case "+":
+				active[match[4]]++
+			case "-":
+				deactivate(line, match[1])
+			}
+		}
+	}
+
+	for _, block := range blocks {
+		errs = append(errs, MermaidError{block.number, fmt.Sprintf("%q block is never closed with \"end\"", strings.Fields(block.text)[0])})
+	}
+	return errs
+}
+
+// balanced reports whether the pair of brackets is balanced in text
+func balanced(text string, pair string) bool {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case pair[0]:
+			depth++
+		case pair[1]:
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+func hasStyleKeyword(text string) bool {
+	for _, keyword := range styleKeywords {
+		if strings.HasPrefix(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatMermaidErrors renders validation errors one per line for a repair prompt
+func formatMermaidErrors(errs []MermaidError) string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = "- " + err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
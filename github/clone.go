@@ -1,49 +1,171 @@
 package github
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-// CloneRepository clones a GitHub repository to a temporary directory and returns the path to the cloned repo
-func CloneRepository(repoURL string) (string, error) {
+// CloneOptions controls how a repository is cloned
+type CloneOptions struct {
+	// Ref is the branch, tag or full commit SHA to check out. Empty clones
+	// the default branch.
+	Ref string
+
+	// Token authenticates HTTPS clones from github.com and file downloads of
+	// private repositories. It is passed to git through the environment so it
+	// never appears in the command line or the cloned repository's config.
+	Token string
+
+	// Logger receives progress messages, nil uses slog's default logger
+	Logger *slog.Logger
+}
+
+// logger returns the configured logger or slog's default logger
+func (opts CloneOptions) logger() *slog.Logger {
+	if opts.Logger == nil {
+		return slog.Default()
+	}
+	return opts.Logger
+}
+
+// rawContentURL is the host serving raw file contents, replaced in tests
+var rawContentURL = "https://raw.githubusercontent.com"
+
+// CloneRepository clones a GitHub repository to a temporary directory and returns the path to the cloned repo.
+// Cancelling ctx kills git and removes the temporary directory.
+func CloneRepository(ctx context.Context, repoURL string, opts CloneOptions) (string, error) {
 	// Check if this is a specific file request
 	if strings.HasPrefix(repoURL, "@") {
-		return FetchSingleFile(strings.TrimPrefix(repoURL, "@"))
+		return FetchSingleFile(ctx, strings.TrimPrefix(repoURL, "@"), opts.Token)
 	}
 
 	// Create a temporary directory
 	tempDir, err := os.MkdirTemp("", "mermgen-")
-	fmt.Println("tempDir", tempDir)
 	if err != nil {
 		return "", fmt.Errorf("failed to create temporary directory: %w", err)
 	}
+	opts.logger().Debug("Cloning into temporary directory", "dir", tempDir)
 
 	// Ensure the URL is in the correct format
-	if !strings.HasPrefix(repoURL, "https://") && !strings.HasPrefix(repoURL, "git@") {
+	if !strings.HasPrefix(repoURL, "https://") && !strings.HasPrefix(repoURL, "git@") && !strings.HasPrefix(repoURL, "file://") {
 		repoURL = "https://" + repoURL
 	}
 
-	// Clone the repository
-	cmd := exec.Command("git", "clone", "--depth=1", repoURL, tempDir)
-	output, err := cmd.CombinedOutput()
+	env := gitAuthEnv(repoURL, opts.Token)
+
+	if opts.Ref == "" {
+		// Clone the default branch
+		err = runGit(ctx, "", env, "clone", "--depth=1", repoURL, tempDir)
+	} else {
+		err = fetchRef(ctx, tempDir, env, repoURL, opts.Ref)
+	}
 	if err != nil {
 		os.RemoveAll(tempDir) // Clean up the temp directory on error
-		return "", fmt.Errorf("git clone failed: %w\nOutput: %s", err, output)
+		if opts.Token != "" {
+			err = &redactedError{err: err, token: opts.Token}
+		}
+		return "", err
 	}
 
 	return tempDir, nil
 }
 
+// fetchRef shallowly fetches a single branch, tag or commit into dir and
+// checks it out. Unlike "git clone --branch", fetching by name also accepts
+// commit SHAs.
+func fetchRef(ctx context.Context, dir string, env []string, repoURL, ref string) error {
+	steps := [][]string{
+		{"init", "--quiet"},
+		{"remote", "add", "origin", repoURL},
+		{"fetch", "--depth=1", "origin", ref},
+		{"checkout", "--quiet", "FETCH_HEAD"},
+	}
+	for _, args := range steps {
+		if err := runGit(ctx, dir, env, args...); err != nil {
+			return fmt.Errorf("failed to fetch ref %s: %w", ref, err)
+		}
+	}
+	return nil
+}
+
+// runGit runs a git command in dir with additional environment variables,
+// including its output in the error. The command is killed when ctx is done.
+func runGit(ctx context.Context, dir string, env []string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("git %s interrupted: %w", args[0], ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("git %s failed: %w\nOutput: %s", args[0], err, output)
+	}
+	return nil
+}
+
+// gitAuthEnv returns environment variables that make git send token as
+// HTTP basic credentials, the scheme GitHub expects for access tokens. The
+// header is scoped to github.com, so other hosts never receive the token, and
+// is added after any GIT_CONFIG_* entries already in the environment.
+func gitAuthEnv(repoURL, token string) []string {
+	if token == "" || !strings.HasPrefix(repoURL, githubURL) {
+		return nil
+	}
+	index := 0
+	if count, err := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT")); err == nil && count > 0 {
+		index = count
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	return []string{
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", index+1),
+		fmt.Sprintf("GIT_CONFIG_KEY_%d=http.%s.extraHeader", index, githubURL),
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", index, credentials),
+		"GIT_TERMINAL_PROMPT=0", // Fail instead of asking for a password
+	}
+}
+
+// githubURL is the only origin token is sent to when cloning
+const githubURL = "https://github.com/"
+
+// redactedError hides a token in the message of the error it wraps
+type redactedError struct {
+	err   error
+	token string
+}
+
+func (e *redactedError) Error() string {
+	return redactToken(e.err.Error(), e.token)
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactToken removes token, plain and in its encoded credential form, from text
+func redactToken(text, token string) string {
+	if token == "" {
+		return text
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	text = strings.ReplaceAll(text, credentials, "[REDACTED]")
+	return strings.ReplaceAll(text, token, "[REDACTED]")
+}
+
 // FetchSingleFile downloads a single file from GitHub
 // Example URL: https://github.com/spf13/cobra/blob/main/cobra.go
-func FetchSingleFile(fileURL string) (string, error) {
+// A non-empty token authenticates the download of files in private repositories.
+func FetchSingleFile(ctx context.Context, fileURL string, token string) (string, error) {
 	// Create a temporary directory
 	tempDir, err := os.MkdirTemp("", "mermgen-file-")
 	if err != nil {
@@ -64,13 +186,21 @@ func FetchSingleFile(fileURL string) (string, error) {
 	path := matches[4]
 
 	// Generate the raw content URL
-	rawURL := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", owner, repo, branch, path)
+	rawURL := fmt.Sprintf("%s/%s/%s/%s/%s", rawContentURL, owner, repo, branch, path)
 
 	// Download the file
-	resp, err := http.Get(rawURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		os.RemoveAll(tempDir)
-		return "", fmt.Errorf("failed to download file: %w", err)
+		return "", fmt.Errorf("failed to download file: %s", redactToken(err.Error(), token))
 	}
 	defer resp.Body.Close()
 
@@ -1,7 +1,13 @@
 package github
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -15,7 +21,7 @@ func TestCloneRepository(t *testing.T) {
 	// Test with a small, public repository
 	repoURL := "github.com/golang/example"
 	
-	tempDir, err := CloneRepository(repoURL)
+	tempDir, err := CloneRepository(context.Background(), repoURL, CloneOptions{})
 	if err != nil {
 		t.Fatalf("Failed to clone repository: %v", err)
 	}
@@ -47,4 +53,162 @@ func TestCloneRepository(t *testing.T) {
 	if !hasReadme {
 		t.Error("Cloned repository doesn't have a README file")
 	}
-} 
\ No newline at end of file
+}
+
+// createTestRepository creates a local repository with a tagged first
+// commit and a second commit on the default branch
+func createTestRepository(t *testing.T) (url string, firstSHA string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	git("init", "--quiet")
+	git("config", "uploadpack.allowAnySHA1InWant", "true")
+	write("version.txt", "v1")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "first")
+	git("tag", "v1.0.0")
+	firstSHA = git("rev-parse", "HEAD")
+	write("version.txt", "v2")
+	git("commit", "--quiet", "-am", "second")
+
+	return "file://" + dir, firstSHA
+}
+
+func TestCloneRepositoryRef(t *testing.T) {
+	repoURL, firstSHA := createTestRepository(t)
+
+	for _, ref := range []string{"", "v1.0.0", firstSHA} {
+		tempDir, err := CloneRepository(context.Background(), repoURL, CloneOptions{Ref: ref})
+		if err != nil {
+			t.Fatalf("Failed to clone ref %q: %v", ref, err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		content, err := os.ReadFile(filepath.Join(tempDir, "version.txt"))
+		if err != nil {
+			t.Fatalf("Failed to read cloned file: %v", err)
+		}
+		expected := "v1"
+		if ref == "" {
+			expected = "v2"
+		}
+		if string(content) != expected {
+			t.Errorf("Expected %s at ref %q, got %s", expected, ref, content)
+		}
+	}
+
+	if _, err := CloneRepository(context.Background(), repoURL, CloneOptions{Ref: "missing"}); err == nil {
+		t.Error("Expected an error for a missing ref")
+	}
+
+	// A cancelled clone fails without leaving the temporary directory behind
+	before, _ := filepath.Glob(filepath.Join(os.TempDir(), "mermgen-*"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CloneRepository(ctx, repoURL, CloneOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected an interrupted clone, got %v", err)
+	}
+	if _, err := CloneRepository(ctx, repoURL, CloneOptions{Token: "secret"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the redacted error to wrap the cancellation, got %v", err)
+	}
+	after, _ := filepath.Glob(filepath.Join(os.TempDir(), "mermgen-*"))
+	if len(after) > len(before) {
+		t.Errorf("Expected the temporary directory to be removed, found %v", after)
+	}
+}
+
+func TestGitAuthEnv(t *testing.T) {
+	t.Setenv("GIT_CONFIG_COUNT", "")
+	for _, repoURL := range []string{"https://gitlab.example.com/org/repo", "https://github.com.evil.example/org/repo", "git@github.com:org/repo", "file:///tmp/repo"} {
+		if env := gitAuthEnv(repoURL, "ghp_secret123"); env != nil {
+			t.Errorf("Expected no auth env for %s, got %v", repoURL, env)
+		}
+	}
+	if env := gitAuthEnv("https://github.com/org/repo", ""); env != nil {
+		t.Errorf("Expected no auth env without a token, got %v", env)
+	}
+
+	env := gitAuthEnv("https://github.com/org/repo", "ghp_secret123")
+	if len(env) == 0 || env[0] != "GIT_CONFIG_COUNT=1" || env[1] != "GIT_CONFIG_KEY_0=http.https://github.com/.extraHeader" {
+		t.Errorf("Expected a header scoped to github.com, got %v", env)
+	}
+
+	// Entries the user already configured through the environment are kept
+	t.Setenv("GIT_CONFIG_COUNT", "2")
+	env = gitAuthEnv("https://github.com/org/repo", "ghp_secret123")
+	if env[0] != "GIT_CONFIG_COUNT=3" || !strings.HasPrefix(env[1], "GIT_CONFIG_KEY_2=") || !strings.HasPrefix(env[2], "GIT_CONFIG_VALUE_2=") {
+		t.Errorf("Expected the header to be appended after existing entries, got %v", env)
+	}
+}
+
+func TestRedactToken(t *testing.T) {
+	t.Setenv("GIT_CONFIG_COUNT", "")
+	token := "ghp_secret123"
+	env := gitAuthEnv("https://github.com/org/repo", token)
+	output := "fatal: could not read from https://" + token + "@github.com\n" + strings.Join(env, "\n")
+
+	redacted := redactToken(output, token)
+	if strings.Contains(redacted, token) || strings.Contains(redacted, strings.TrimPrefix(env[2], "GIT_CONFIG_VALUE_0=Authorization: Basic ")) {
+		t.Errorf("Token was not redacted: %s", redacted)
+	}
+	if redactToken(output, "") != output {
+		t.Error("Expected output to be unchanged without a token")
+	}
+}
+
+func TestFetchSingleFileToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path != "/org/repo/main/cmd/main.go" {
+			t.Errorf("Unexpected request path %s", r.URL.Path)
+		}
+		w.Write([]byte("package main\n"))
+	}))
+	defer server.Close()
+
+	original := rawContentURL
+	rawContentURL = server.URL
+	defer func() { rawContentURL = original }()
+
+	fileURL := "https://github.com/org/repo/blob/main/cmd/main.go"
+	if _, err := FetchSingleFile(context.Background(), fileURL, ""); err == nil {
+		t.Error("Expected unauthenticated download to fail")
+	}
+
+	filePath, err := FetchSingleFile(context.Background(), fileURL, "secret")
+	if err != nil {
+		t.Fatalf("Failed to fetch file: %v", err)
+	}
+	defer os.RemoveAll(filepath.Dir(filePath))
+
+	content, err := os.ReadFile(filePath)
+	if err != nil || string(content) != "package main\n" {
+		t.Errorf("Unexpected file content %q (%v)", content, err)
+	}
+}
@@ -0,0 +1,42 @@
+// Package diskcache holds the on-disk layout shared by mermgen's caches
+package diskcache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Path returns the file of an entry in dir, spreading entries over
+// subdirectories named by the first byte of the hex encoded key
+func Path(dir, key, ext string) string {
+	return filepath.Join(dir, key[:2], key+ext)
+}
+
+// WriteFile stores an entry at path with the content produced by write. The
+// entry is written to a temporary file and renamed, so concurrent runs never
+// read partial entries.
+func WriteFile(path string, write func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating cache directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "entry-*")
+	if err != nil {
+		return fmt.Errorf("error creating cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package diskcache
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := Path(dir, "abcdef", ".json")
+	if path != filepath.Join(dir, "ab", "abcdef.json") {
+		t.Errorf("Unexpected entry path %s", path)
+	}
+
+	if err := WriteFile(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "entry")
+		return err
+	}); err != nil {
+		t.Fatalf("Failed to write entry: %v", err)
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "entry" {
+		t.Errorf("Unexpected entry content %q (%v)", content, err)
+	}
+
+	// A failed write keeps the previous entry and leaves no temporary file behind
+	failure := errors.New("encoding failed")
+	if err := WriteFile(path, func(w io.Writer) error { return failure }); !errors.Is(err, failure) {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "entry" {
+		t.Errorf("Expected the previous entry to be kept, got %q", content)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("Expected only the entry in the cache directory, got %d files", len(entries))
+	}
+}
@@ -1,70 +1,413 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 
+	"github.com/Nurozen/mermgen/config"
 	"github.com/Nurozen/mermgen/generator"
 	"github.com/Nurozen/mermgen/github"
 	"github.com/Nurozen/mermgen/parser"
-	"github.com/joho/godotenv"
+)
+
+// Exit codes of a run whose diagrams were not all generated as requested
+const (
+	exitFailed   = 1 // Diagrams are missing, or the run failed
+	exitDegraded = 2 // Every diagram was written, but some only as a fallback
 )
 
 func main() {
-	if err := godotenv.Load(".env.local"); err != nil {
-		log.Fatal("Error loading .env.local file")
+	err := run()
+	var generateErr *generator.GenerateError
+	switch {
+	case err == nil:
+	case errors.As(err, &generateErr) && !generateErr.Failed():
+		slog.Error("Some diagrams were replaced by a fallback", "diagrams", len(generateErr.Diagrams))
+		os.Exit(exitDegraded)
+	case errors.As(err, &generateErr):
+		slog.Error("Some diagrams could not be generated", "diagrams", len(generateErr.Diagrams))
+		os.Exit(exitFailed)
+	default:
+		slog.Error(err.Error())
+		os.Exit(exitFailed)
+	}
+}
+
+// envFlags are the flags whose defaults come from environment variables,
+// which take precedence over the configuration file
+var envFlags = map[string]string{
+	"provider": "MERMGEN_PROVIDER",
+	"model":    "MERMGEN_MODEL",
+	"base-url": "MERMGEN_BASE_URL",
+}
+
+// run is the body of main. It returns errors instead of exiting so the
+// deferred cleanup of a cloned repository always runs.
+func run() error {
+	// Local secrets are optional, the environment may already provide them
+	if err := config.LoadEnvFile(".env.local"); err != nil {
+		return err
 	}
 
 	// Define command line arguments
-	repoURL := flag.String("repo", "", "GitHub repository URL (e.g., github.com/user/repo)")
+	configPath := flag.String("config", "", "Configuration file (mermgen.yaml or .mermgen.toml); defaults to one in the local project directory or the working directory")
+	repoURL := flag.String("repo", "", "GitHub repository URL (e.g., github.com/user/repo) or local directory")
+	ref := flag.String("ref", "", "Branch, tag or commit SHA to clone (defaults to the default branch)")
+	token := flag.String("token", os.Getenv("GITHUB_TOKEN"), "GitHub token for cloning private repositories (defaults to GITHUB_TOKEN)")
+	localPath := flag.String("path", "", "Local directory to analyze instead of cloning a repository")
 	outputDir := flag.String("output", "diagrams", "Output directory for generated diagrams")
+	externalDeps := flag.String("external", "collapse", "External dependencies in the package diagram: hide, collapse or full")
+	entry := flag.String("entry", "", "Entry function of the sequence diagram (e.g., main.main or pkg.Handler.ServeHTTP)")
+	depth := flag.Int("depth", 3, "Number of call levels followed by the sequence diagram")
+	backend := flag.String("backend", "tree-sitter", "Parser backend: tree-sitter, or packages to type-check the project for exact cross-package references")
+	deterministic := flag.Bool("deterministic", false, "Render diagrams from parsed symbols without calling the AI service")
+	diagramTypes := flag.String("diagram", "all", "Comma separated diagram types to generate: class, package, sequence, modules or all (class, package and sequence)")
+	fallback := flag.String("fallback", "none", "Replacement for diagrams that cannot be generated: none, placeholder or deterministic; the run exits with status 2 when a fallback was used and 1 when diagrams are missing")
+	perModule := flag.Bool("per-module", false, "In multi-module repositories, generate the diagrams of every module into its own output directory plus a modules overview")
+	providerName := flag.String("provider", os.Getenv("MERMGEN_PROVIDER"), "AI provider: anthropic, gemini or openai (OpenAI-compatible servers such as Ollama)")
+	model := flag.String("model", os.Getenv("MERMGEN_MODEL"), "Model used by the AI provider (defaults to the provider's default model)")
+	baseURL := flag.String("base-url", os.Getenv("MERMGEN_BASE_URL"), "API endpoint of the AI provider, e.g. http://localhost:11434/v1 for Ollama")
+	promptTokens := flag.Int("prompt-tokens", generator.DefaultTokenBudget.PromptTokens, "Maximum estimated tokens of project data per prompt; larger projects are summarized package by package")
+	summaryTokens := flag.Int("summary-tokens", generator.DefaultTokenBudget.SummaryTokens, "Maximum tokens of each package summary")
+	diagramTokens := flag.Int("diagram-tokens", 0, "Maximum tokens of each generated diagram (0 uses the provider's default)")
+	cacheDir := flag.String("cache-dir", "", "Directory of the AI response cache (defaults to the user cache directory)")
+	noCache := flag.Bool("no-cache", false, "Call the AI provider even when a cached response exists; responses are still cached")
+	parseCacheDir := flag.String("parse-cache-dir", "", "Directory of the parse cache, which skips reparsing unchanged files (defaults to the user cache directory)")
+	workers := flag.Int("workers", 0, "Number of files parsed in parallel (defaults to the number of CPUs)")
+	clearCache := flag.Bool("clear-cache", false, "Remove all cached AI responses and parse results before running")
+	include := flag.String("include", "", "Comma separated globs of files to analyze, relative to the project (e.g., 'internal/**,cmd/**')")
+	exclude := flag.String("exclude", "", "Comma separated globs of files and directories to skip (e.g., '**/*.pb.go,gen')")
+	allFiles := flag.Bool("all-files", false, "Also analyze vendor, testdata, test, generated and git-ignored files")
+	goos := flag.String("goos", "", "Target operating system whose files are analyzed (defaults to GOOS or the host)")
+	goarch := flag.String("goarch", "", "Target architecture whose files are analyzed (defaults to GOARCH or the host)")
+	tags := flag.String("tags", "", "Comma separated build tags considered satisfied")
+	timeout := flag.Duration("timeout", 0, "Abort the run after this duration, e.g. 10m (0 for no limit)")
+	verbose := flag.Bool("v", false, "Verbose output, including every parsed file and rate limit wait")
+	quiet := flag.Bool("q", false, "Only report warnings and errors")
+	logFormat := flag.String("log-format", "text", "Format of the log written to stderr: text or json")
 	flag.Parse()
 
-	if *repoURL == "" {
-		fmt.Println("Please provide a GitHub repository URL with -repo")
+	// Progress goes to stderr, so stdout stays free for results
+	logger, err := newLogger(os.Stderr, *logFormat, *verbose, *quiet)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	// Settings committed next to the code fill in what the command line and
+	// environment leave open. Cloned repositories are not searched, since
+	// their configuration could send the API key to another server.
+	if *configPath == "" {
+		dirs := []string{"."}
+		if dir := localProjectDir(*repoURL, *localPath); dir != "" {
+			dirs = []string{dir, "."}
+		}
+		*configPath = config.Find(dirs...)
+	}
+	if *configPath != "" {
+		settings, err := config.Load(*configPath)
+		if err != nil {
+			return err
+		}
+		if err := settings.Apply(flag.CommandLine, envFlags); err != nil {
+			return err
+		}
+		logger.Info("Loaded configuration", "path", *configPath)
+	}
+
+	// Ctrl-C and SIGTERM cancel the run; a second signal terminates immediately
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// Restoring the default handling lets the second signal kill the process
+		<-sigCtx.Done()
+		stop()
+	}()
+	ctx := sigCtx
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *cacheDir == "" {
+		dir, err := generator.DefaultCacheDir()
+		if err != nil {
+			return err
+		}
+		*cacheDir = dir
+	}
+	if *parseCacheDir == "" {
+		dir, err := parser.DefaultParseCacheDir()
+		if err != nil {
+			return err
+		}
+		*parseCacheDir = dir
+	}
+	cache := &generator.ResponseCache{Dir: *cacheDir, Refresh: *noCache}
+	parseCache := &parser.ParseCache{Dir: *parseCacheDir}
+	if *clearCache {
+		if err := cache.Clear(); err != nil {
+			return err
+		}
+		if err := parseCache.Clear(); err != nil {
+			return err
+		}
+		logger.Info("Cleared caches", "responses", *cacheDir, "parse", *parseCacheDir)
+		if *repoURL == "" && *localPath == "" {
+			return nil
+		}
+	}
+
+	if *repoURL == "" && *localPath == "" {
 		flag.Usage()
-		os.Exit(1)
+		return errors.New("please provide a GitHub repository URL with -repo or a local directory with -path")
+	}
+
+	externalMode, err := generator.ParseExternalDeps(*externalDeps)
+	if err != nil {
+		return err
+	}
+
+	parserBackend, err := parser.ParseBackend(*backend)
+	if err != nil {
+		return err
+	}
+
+	kinds, err := generator.ParseDiagramKinds(*diagramTypes)
+	if err != nil {
+		return err
+	}
+
+	fallbackPolicy, err := generator.ParseFallbackPolicy(*fallback)
+	if err != nil {
+		return err
+	}
+
+	// Select the AI provider. Without credentials the diagrams need a
+	// fallback, so the run fails early when there is none.
+	var provider generator.Provider
+	if !*deterministic {
+		provider, err = generator.NewProvider(generator.ProviderConfig{
+			Name:    *providerName,
+			Model:   *model,
+			BaseURL: *baseURL,
+		})
+		if errors.Is(err, generator.ErrMissingAPIKey) {
+			if fallbackPolicy == generator.FallbackNone {
+				return fmt.Errorf("%w; use -deterministic or -fallback to generate diagrams without the AI service", err)
+			}
+			logger.Warn("No AI provider available, diagrams will use the fallback", "fallback", fallbackPolicy, "reason", err)
+		} else if err != nil {
+			return err
+		} else {
+			logger.Info("Using AI provider", "provider", provider.Name(), "model", provider.Model())
+		}
 	}
 
 	// Create output directory if it doesn't exist
-	err := os.MkdirAll(*outputDir, 0755)
+	err = os.MkdirAll(*outputDir, 0755)
 	if err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Clone the repository
-	fmt.Printf("Cloning repository: %s\n", *repoURL)
-	repoPath, err := github.CloneRepository(*repoURL)
+	// Analyze a local checkout in place, or clone the repository
+	repoPath, cleanup, err := resolveRepository(ctx, *repoURL, *localPath, github.CloneOptions{Ref: *ref, Token: *token, Logger: logger})
 	if err != nil {
-		log.Fatalf("Failed to clone repository: %v", err)
+		return err
 	}
-	defer os.RemoveAll(repoPath) // Clean up the cloned repo after we're done
+	defer cleanup()
 
 	// Parse the Go code with tree-sitter
-	fmt.Println("Parsing Go code...")
-	parsedData, err := parser.ParseGoProject(repoPath)
+	logger.Info("Parsing Go code", "path", repoPath)
+	parsedData, err := parser.ParseGoProject(ctx, repoPath, parser.ParseOptions{
+		Backend: parserBackend,
+		Cache:   parseCache,
+		Workers: *workers,
+
+		Include:           splitList(*include),
+		Exclude:           splitList(*exclude),
+		NoDefaultExcludes: *allFiles,
+
+		GOOS:      *goos,
+		GOARCH:    *goarch,
+		BuildTags: splitList(*tags),
+
+		Logger: logger,
+	})
 	if err != nil {
-		log.Fatalf("Failed to parse Go code: %v", err)
+		return fmt.Errorf("failed to parse Go code: %w", err)
 	}
 
 	// Generate Mermaid diagrams
-	fmt.Println("Generating Mermaid diagrams...")
-	diagrams, err := generator.GenerateDiagrams(parsedData)
-	if err != nil {
-		log.Fatalf("Failed to generate diagrams: %v", err)
+	logger.Info("Generating Mermaid diagrams", "kinds", len(kinds))
+	limiter := generator.NewRateLimiter(generator.DefaultRequestInterval, 1)
+	limiter.Logger = logger
+	genOpts := generator.Options{
+		Provider:      provider,
+		Deterministic: *deterministic,
+		ExternalDeps:  externalMode,
+		SequenceEntry: *entry,
+		SequenceDepth: *depth,
+		Fallback:      fallbackPolicy,
+		Cache:         cache,
+		Budget: generator.TokenBudget{
+			PromptTokens:  *promptTokens,
+			SummaryTokens: *summaryTokens,
+			DiagramTokens: *diagramTokens,
+		},
+		// Shared by the diagrams of every module
+		Limiter: limiter,
+		Logger:  logger,
+	}
+
+	// Failed and degraded diagrams do not stop the run, they are reported
+	// together once every diagram has been written
+	var results map[string]map[string]string
+	if !*perModule || len(parsedData.Modules) < 2 {
+		var diagrams map[string]string
+		diagrams, err = generator.GenerateDiagrams(ctx, parsedData, kinds, genOpts)
+		results = map[string]map[string]string{".": diagrams}
+	} else {
+		// One set of diagrams per module, in a directory mirroring the module's
+		// location in the repository, and the overview at the output root
+		results, err = generator.GenerateModuleDiagrams(ctx, parsedData, kinds, genOpts)
+	}
+	var generateErr *generator.GenerateError
+	if err != nil && !errors.As(err, &generateErr) {
+		return fmt.Errorf("failed to generate diagrams: %w", err)
+	}
+
+	count := 0
+	for dir, diagrams := range results {
+		dir = filepath.Join(*outputDir, filepath.FromSlash(dir))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+		count += writeDiagrams(logger, dir, diagrams)
 	}
+	logger.Info("Generated diagrams", "count", count, "output", *outputDir)
 
-	// Save diagrams to output directory
+	var degraded []*generator.DiagramError
+	if generateErr != nil {
+		degraded = generateErr.Diagrams
+	}
+	if len(degraded) == 0 {
+		return nil
+	}
+	for _, diagramErr := range degraded {
+		if diagramErr.Fallback == generator.FallbackNone {
+			logger.Error("Summary: diagram missing", "kind", diagramErr.Kind, "error", diagramErr.Err)
+		} else {
+			logger.Warn("Summary: diagram degraded", "kind", diagramErr.Kind, "fallback", diagramErr.Fallback, "error", diagramErr.Err)
+		}
+	}
+	return &generator.GenerateError{Diagrams: degraded}
+}
+
+// writeDiagrams saves diagrams as Markdown files in dir and returns how many
+// were written
+func writeDiagrams(logger *slog.Logger, dir string, diagrams map[string]string) int {
+	count := 0
 	for name, content := range diagrams {
-		filePath := filepath.Join(*outputDir, name+".md")
+		filePath := filepath.Join(dir, name+".md")
 		err := os.WriteFile(filePath, []byte(content), 0644)
 		if err != nil {
-			log.Printf("Error writing diagram %s: %v", name, err)
+			logger.Error("Error writing diagram", "name", name, "error", err)
+			continue
 		}
+		count++
 	}
+	return count
+}
+
+// resolveRepository returns the directory to analyze and a cleanup function.
+// Local directories, given with -path or as -repo, are used in place and
+// never removed; anything else is cloned into a temporary directory.
+func resolveRepository(ctx context.Context, repoURL, localPath string, cloneOpts github.CloneOptions) (string, func(), error) {
+	localPath = localProjectDir(repoURL, localPath)
 
-	fmt.Printf("Generated %d diagrams in %s\n", len(diagrams), *outputDir)
+	if localPath != "" {
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open local directory: %w", err)
+		}
+		if !info.IsDir() {
+			return "", nil, fmt.Errorf("%s is not a directory", localPath)
+		}
+		absPath, err := filepath.Abs(localPath)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to resolve local directory: %w", err)
+		}
+		cloneOpts.Logger.Info("Using local directory", "path", absPath)
+		return absPath, func() {}, nil
+	}
+
+	cloneOpts.Logger.Info("Cloning repository", "repo", repoURL, "ref", cloneOpts.Ref)
+	repoPath, err := github.CloneRepository(ctx, repoURL, cloneOpts)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to clone repository: %w", err)
+	}
+	// Clean up the cloned repo after we're done. A single downloaded file
+	// lives in a temporary directory of its own.
+	tempDir := repoPath
+	if strings.HasPrefix(repoURL, "@") {
+		tempDir = filepath.Dir(repoPath)
+	}
+	return repoPath, func() { os.RemoveAll(tempDir) }, nil
+}
+
+// localProjectDir returns the local directory given with -path or as -repo,
+// or "" if the repository has to be cloned
+func localProjectDir(repoURL, localPath string) string {
+	if localPath != "" {
+		return localPath
+	}
+	if info, err := os.Stat(repoURL); err == nil && info.IsDir() {
+		return repoURL
+	}
+	return ""
+}
+
+// newLogger returns the logger of a run, writing text or JSON records to w.
+// Verbose output includes debug records, quiet output only warnings and errors.
+func newLogger(w io.Writer, format string, verbose, quiet bool) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	switch {
+	case verbose && quiet:
+		return nil, errors.New("-v and -q cannot be combined")
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelWarn
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q (expected text or json)", format)
+}
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/Nurozen/mermgen/internal/diskcache"
+)
+
+// parseCacheVersion is part of every cache key. Bump it whenever the data
+// extracted by parseGoSource changes, so stale entries are ignored.
+const parseCacheVersion = "1"
+
+// ParseCache stores the parse results of Go files on disk, addressed by a
+// hash of the file content. Unchanged files are loaded from the cache
+// instead of being parsed again, and the cache is shared by every project
+// parsed with the same directory.
+type ParseCache struct {
+	Dir string
+}
+
+// DefaultParseCacheDir returns the parse cache directory used when none is
+// configured, inside the user's cache directory
+func DefaultParseCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "mermgen", "parse"), nil
+}
+
+// Clear removes every cached parse result
+func (c *ParseCache) Clear() error {
+	if err := os.RemoveAll(c.Dir); err != nil {
+		return fmt.Errorf("error clearing parse cache: %w", err)
+	}
+	return nil
+}
+
+// key hashes the content of a file
+func (c *ParseCache) key(content []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(parseCacheVersion + "\x00"))
+	hash.Write(content)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// path returns the file of the entry for key
+func (c *ParseCache) path(key string) string {
+	return diskcache.Path(c.Dir, key, ".gob")
+}
+
+// Get returns the cached parse result of a file with the given content
+func (c *ParseCache) Get(content []byte) (*FileData, bool) {
+	file, err := os.Open(c.path(c.key(content)))
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	var fileData FileData
+	if err := gob.NewDecoder(file).Decode(&fileData); err != nil {
+		return nil, false
+	}
+	// The content is not stored twice
+	fileData.Content = string(content)
+	return &fileData, true
+}
+
+// Put stores the parse result of a file
+func (c *ParseCache) Put(content []byte, fileData *FileData) error {
+	entry := *fileData
+	entry.Content = ""
+	return diskcache.WriteFile(c.path(c.key(content)), func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(&entry)
+	})
+}
+
+// lookup is Get on a cache that may be nil
+func (c *ParseCache) lookup(content []byte) (*FileData, bool) {
+	if c == nil {
+		return nil, false
+	}
+	return c.Get(content)
+}
+
+// store is Put on a cache that may be nil. Failing to cache a file only
+// costs parsing it again on the next run.
+func (c *ParseCache) store(content []byte, fileData *FileData, logger *slog.Logger) {
+	if c == nil {
+		return
+	}
+	if err := c.Put(content, fileData); err != nil {
+		logger.Warn("Failed to cache parse result", "error", err)
+	}
+}
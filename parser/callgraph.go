@@ -0,0 +1,344 @@
+package parser
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// CallGraph is the static call graph of a project. Functions are identified
+// by "<import path>.<Name>" and methods by "<import path>.<Type>.<Method>".
+type CallGraph struct {
+	Funcs map[string]*FuncNode
+}
+
+// FuncNode is a function or method of the project together with its
+// resolved outgoing calls
+type FuncNode struct {
+	ID          string
+	PackagePath string
+	PackageName string
+	Receiver    string // Receiver type name, empty for functions
+	Name        string
+	File        string
+	Calls       []CallEdge
+}
+
+// CallEdge is a single resolved call. Callees outside the project use the
+// same id scheme and have Kind stdlib or external.
+type CallEdge struct {
+	Callee      string
+	PackagePath string
+	Receiver    string // Receiver type name for method calls
+	Name        string
+	Kind        ImportKind
+	Position
+}
+
+// callScope is what a function body can refer to when resolving calls
+type callScope struct {
+	pkg     *Package
+	imports map[string]string // Package name or alias in the file -> import path
+	locals  map[string]string // Receiver and parameter names -> type expression
+}
+
+// CallGraph resolves the calls recorded in every function and method of the
+// project. Plain calls are resolved to functions of the same package,
+// selector calls to functions of imported packages and, where the operand
+// is the receiver, a parameter or one of their fields, to methods.
+// Calls that cannot be resolved statically are dropped.
+func (p *RawProjectData) CallGraph() *CallGraph {
+	graph := &CallGraph{Funcs: make(map[string]*FuncNode)}
+
+	// First pass: register every function and method
+	for _, pkg := range p.Packages {
+		for _, filePath := range pkg.Files {
+			fileData := p.Files[filePath]
+			for _, fn := range append(append([]Func(nil), fileData.Functions...), fileData.Methods...) {
+				node := &FuncNode{
+					PackagePath: pkg.ImportPath,
+					PackageName: pkg.Name,
+					Name:        fn.Name,
+					File:        filePath,
+				}
+				if fn.Receiver != nil {
+					node.Receiver = fn.Receiver.Type
+				}
+				node.ID = funcID(pkg.ImportPath, node.Receiver, fn.Name)
+				graph.Funcs[node.ID] = node
+			}
+		}
+	}
+
+	// Second pass: resolve calls
+	for _, pkg := range p.Packages {
+		for _, filePath := range pkg.Files {
+			fileData := p.Files[filePath]
+			imports := p.ImportNames(fileData)
+
+			for _, fn := range append(append([]Func(nil), fileData.Functions...), fileData.Methods...) {
+				scope := callScope{pkg: pkg, imports: imports, locals: make(map[string]string)}
+				recvType := ""
+				if fn.Receiver != nil {
+					recvType = fn.Receiver.Type
+					if fn.Receiver.Name != "" {
+						scope.locals[fn.Receiver.Name] = fn.Receiver.Type
+					}
+				}
+				for _, param := range fn.Params {
+					if param.Name != "" {
+						scope.locals[param.Name] = param.Type
+					}
+				}
+
+				node := graph.Funcs[funcID(pkg.ImportPath, recvType, fn.Name)]
+				for _, call := range fn.Calls {
+					if edge, ok := p.resolveCall(graph, scope, call); ok {
+						node.Calls = append(node.Calls, edge)
+					}
+				}
+			}
+		}
+	}
+
+	return graph
+}
+
+// FindFunc looks up an entry point given as a full id such as
+// "example.com/app/api.Handler.ServeHTTP", or qualified by package name
+// such as "main.main" or "api.Handler.ServeHTTP"
+func (g *CallGraph) FindFunc(name string) (*FuncNode, error) {
+	if node, ok := g.Funcs[name]; ok {
+		return node, nil
+	}
+
+	var matches []*FuncNode
+	for _, node := range g.Funcs {
+		short := node.PackageName + "." + node.Name
+		if node.Receiver != "" {
+			short = node.PackageName + "." + node.Receiver + "." + node.Name
+		}
+		if short == name {
+			matches = append(matches, node)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("function %s not found", name)
+	case 1:
+		return matches[0], nil
+	}
+
+	ids := make([]string, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match.ID)
+	}
+	sort.Strings(ids)
+	return nil, fmt.Errorf("function %s is ambiguous, use one of: %s", name, strings.Join(ids, ", "))
+}
+
+// resolveCall maps a call site to a function of the project or an external package
+func (p *RawProjectData) resolveCall(graph *CallGraph, scope callScope, call Call) (CallEdge, bool) {
+	edge := CallEdge{Name: call.Name, Kind: ImportInternal, Position: call.Position}
+
+	// Type information resolves every call to a declared function or method
+	if call.Target != nil {
+		edge.PackagePath = call.Target.PackagePath
+		edge.Receiver = call.Target.Receiver
+		edge.Callee = funcID(edge.PackagePath, edge.Receiver, call.Name)
+		edge.Kind = p.ClassifyImport(edge.PackagePath)
+		if edge.Kind == ImportInternal {
+			// Interface methods of the project have no body to follow
+			_, ok := graph.Funcs[edge.Callee]
+			return edge, ok
+		}
+		return edge, true
+	}
+
+	if call.Dynamic {
+		return edge, false
+	}
+
+	// Plain call to a function of the same package
+	if call.Qualifier == "" {
+		edge.PackagePath = scope.pkg.ImportPath
+		edge.Callee = funcID(edge.PackagePath, "", call.Name)
+		_, ok := graph.Funcs[edge.Callee]
+		return edge, ok
+	}
+
+	// Call on an imported package, unless the name is shadowed by a local
+	if importPath, ok := scope.imports[call.Qualifier]; ok && scope.locals[call.Qualifier] == "" {
+		edge.Kind = p.ClassifyImport(importPath)
+		edge.PackagePath = importPath
+		edge.Callee = funcID(importPath, "", call.Name)
+		if edge.Kind == ImportInternal {
+			_, ok := graph.Funcs[edge.Callee]
+			return edge, ok
+		}
+		return edge, true
+	}
+
+	// Method call on the receiver, a parameter or a field chain starting at one
+	elems := strings.Split(call.Qualifier, ".")
+	typeExpr, ok := scope.locals[elems[0]]
+	if !ok {
+		return edge, false
+	}
+	pkgPath, typeName := p.resolveTypeExpr(scope, typeExpr)
+	for _, fieldName := range elems[1:] {
+		field, ok := p.findField(pkgPath, typeName, fieldName)
+		if !ok {
+			return edge, false
+		}
+		// Field types are relative to the file declaring the struct
+		pkgPath, typeName = p.resolveTypeExpr(callScope{pkg: p.Packages[pkgPath], imports: field.imports}, field.typeExpr)
+	}
+	if pkgPath == "" || typeName == "" {
+		return edge, false
+	}
+
+	if kind := p.ClassifyImport(pkgPath); kind != ImportInternal {
+		edge.Kind = kind
+		edge.PackagePath = pkgPath
+		edge.Receiver = typeName
+		edge.Callee = funcID(pkgPath, typeName, call.Name)
+		return edge, true
+	}
+
+	edge.Callee = p.findMethod(graph, pkgPath, typeName, call.Name, make(map[string]bool))
+	if method, ok := graph.Funcs[edge.Callee]; ok {
+		// Promoted methods are attributed to the type declaring them
+		edge.PackagePath = method.PackagePath
+		edge.Receiver = method.Receiver
+		return edge, true
+	}
+	return edge, false
+}
+
+// resolveTypeExpr returns the package import path and type name of a
+// (possibly pointer or qualified) named type expression
+func (p *RawProjectData) resolveTypeExpr(scope callScope, typeExpr string) (string, string) {
+	typeExpr = strings.TrimLeft(typeExpr, "*")
+	if idx := strings.Index(typeExpr, "["); idx > 0 {
+		typeExpr = typeExpr[:idx] // Drop type arguments
+	}
+	if qualifier, name, ok := strings.Cut(typeExpr, "."); ok {
+		return scope.imports[qualifier], name
+	}
+	if !isIdentifier(typeExpr) {
+		return "", ""
+	}
+	return scope.pkg.ImportPath, typeExpr
+}
+
+// fieldInfo is a struct field together with the imports of its declaring file
+type fieldInfo struct {
+	typeExpr string
+	imports  map[string]string
+}
+
+// findField looks up a field declared directly by a struct type of the project
+func (p *RawProjectData) findField(pkgPath, typeName, fieldName string) (fieldInfo, bool) {
+	pkg := p.Packages[pkgPath]
+	if pkg == nil {
+		return fieldInfo{}, false
+	}
+	for _, filePath := range pkg.Files {
+		fileData := p.Files[filePath]
+		for _, decl := range fileData.Types {
+			if decl.Name != typeName || decl.Kind != TypeKindStruct {
+				continue
+			}
+			for _, field := range decl.Fields {
+				if field.Name == fieldName {
+					return fieldInfo{typeExpr: field.Type, imports: p.ImportNames(fileData)}, true
+				}
+			}
+		}
+	}
+	return fieldInfo{}, false
+}
+
+// findMethod looks up a method of a project type, following embedded
+// fields for promoted methods
+func (p *RawProjectData) findMethod(graph *CallGraph, pkgPath, typeName, methodName string, seen map[string]bool) string {
+	id := funcID(pkgPath, typeName, methodName)
+	if _, ok := graph.Funcs[id]; ok {
+		return id
+	}
+
+	key := pkgPath + "." + typeName
+	if seen[key] {
+		return ""
+	}
+	seen[key] = true
+
+	pkg := p.Packages[pkgPath]
+	if pkg == nil {
+		return ""
+	}
+	for _, filePath := range pkg.Files {
+		fileData := p.Files[filePath]
+		for _, decl := range fileData.Types {
+			if decl.Name != typeName || decl.Kind != TypeKindStruct {
+				continue
+			}
+			scope := callScope{pkg: pkg, imports: p.ImportNames(fileData)}
+			for _, field := range decl.Fields {
+				if !field.Embedded {
+					continue
+				}
+				embeddedPkg, embeddedType := p.resolveTypeExpr(scope, field.Type)
+				if found := p.findMethod(graph, embeddedPkg, embeddedType, methodName, seen); found != "" {
+					return found
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// ImportNames maps the names a file uses for its imports to their paths.
+// Unnamed imports of project packages use the declared package name, others
+// the last element of the path.
+func (p *RawProjectData) ImportNames(fileData *FileData) map[string]string {
+	names := make(map[string]string)
+	for _, imp := range fileData.Imports {
+		switch imp.Name {
+		case "_", ".":
+			continue
+		case "":
+			name := path.Base(imp.Path)
+			if pkg, ok := p.Packages[imp.Path]; ok {
+				name = pkg.Name
+			}
+			names[name] = imp.Path
+		default:
+			names[imp.Name] = imp.Path
+		}
+	}
+	return names
+}
+
+func funcID(pkgPath, receiver, name string) string {
+	if receiver == "" {
+		return pkgPath + "." + name
+	}
+	return pkgPath + "." + receiver + "." + name
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,268 @@
+package parser
+
+import (
+	"bufio"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// generatedPattern matches the comment marking generated Go files, see
+// https://go.dev/s/generatedcode
+var generatedPattern = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// fileFilter decides which files of a project are parsed. Unless disabled,
+// default rules skip what the go command ignores or what rarely belongs in
+// a diagram: vendor and testdata directories, directories starting with "."
+// or "_", test files, generated files and anything matched by a .gitignore
+// file. Include globs further restrict the files, and Exclude globs skip
+// files and whole directories. Files whose build constraints do not match
+// the target platform and tags are always skipped.
+type fileFilter struct {
+	root     string
+	include  []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	defaults bool
+	ignore   []ignoreRule // Rules of the .gitignore files seen so far
+	build    build.Context
+}
+
+// ignoreRule is a pattern of a .gitignore file
+type ignoreRule struct {
+	dir     string // Slash separated directory of the .gitignore file relative to the root, "" for the root
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func newFileFilter(root string, opts ParseOptions) (*fileFilter, error) {
+	filter := &fileFilter{root: root, defaults: !opts.NoDefaultExcludes, build: opts.buildContext()}
+	for _, glob := range opts.Include {
+		pattern, err := compileGlob(glob)
+		if err != nil {
+			return nil, err
+		}
+		filter.include = append(filter.include, pattern)
+	}
+	for _, glob := range opts.Exclude {
+		pattern, err := compileGlob(glob)
+		if err != nil {
+			return nil, err
+		}
+		filter.exclude = append(filter.exclude, pattern)
+	}
+	return filter, nil
+}
+
+// skipDir reports whether a directory is skipped entirely
+func (f *fileFilter) skipDir(path string) bool {
+	rel := f.rel(path)
+	if rel == "" {
+		f.loadGitignore(path, rel)
+		return false
+	}
+	if matchAny(f.exclude, rel) {
+		return true
+	}
+	if f.defaults && f.skippedByDefault(rel, true) {
+		return true
+	}
+	f.loadGitignore(path, rel)
+	return false
+}
+
+// keepFile reports whether a Go file is parsed
+func (f *fileFilter) keepFile(path string) bool {
+	rel := f.rel(path)
+	if matchAny(f.exclude, rel) || (len(f.include) > 0 && !matchAny(f.include, rel)) {
+		return false
+	}
+	if f.defaults && (f.skippedByDefault(rel, false) || isGeneratedFile(path)) {
+		return false
+	}
+	return f.matchesBuild(path)
+}
+
+// matchesBuild evaluates the //go:build line and the _GOOS and _GOARCH file
+// name suffixes of a file against the target platform and tags
+func (f *fileFilter) matchesBuild(path string) bool {
+	match, err := f.build.MatchFile(filepath.Dir(path), filepath.Base(path))
+	// Unreadable files are reported by the parser
+	return match || err != nil
+}
+
+// skippedByDefault applies the default rules, except the check for
+// generated code, to a file or directory. Parent directories have already
+// been checked when the walk entered them.
+func (f *fileFilter) skippedByDefault(rel string, isDir bool) bool {
+	name := path.Base(rel)
+	if isDir {
+		if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			return true
+		}
+	} else if strings.HasSuffix(name, "_test.go") {
+		return true
+	}
+	return f.ignored(rel, isDir)
+}
+
+// rel returns the slash separated path relative to the root, or the base
+// name when the root is a single file
+func (f *fileFilter) rel(path string) string {
+	rel, err := filepath.Rel(f.root, path)
+	if err != nil || rel == "." {
+		if path == f.root {
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return filepath.Base(path)
+			}
+		}
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// loadGitignore reads the .gitignore file of a directory, if there is one.
+// Directories are visited before their contents, so the rules of every
+// parent directory are known when a path is checked.
+func (f *fileFilter) loadGitignore(dir, rel string) {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " ")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{dir: rel}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// Patterns without a slash match at any depth, others are anchored
+		// at the directory of the .gitignore file
+		if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		pattern, err := globRegexp(strings.TrimPrefix(line, "/"))
+		if err != nil {
+			continue
+		}
+		rule.pattern = pattern
+		f.ignore = append(f.ignore, rule)
+	}
+}
+
+// ignored reports whether a path is ignored by the .gitignore rules. The
+// last matching rule wins, and rules of deeper files are loaded later.
+func (f *fileFilter) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range f.ignore {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		target := rel
+		if rule.dir != "" {
+			if !strings.HasPrefix(rel, rule.dir+"/") {
+				continue
+			}
+			target = strings.TrimPrefix(rel, rule.dir+"/")
+		}
+		if rule.pattern.MatchString(target) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// isGeneratedFile reports whether a Go file carries the generated code
+// comment before its package clause
+func isGeneratedFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if generatedPattern.MatchString(line) {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+	}
+	return false
+}
+
+// compileGlob translates a glob into a regular expression over slash
+// separated relative paths. "*" and "?" do not cross directories, "**"
+// matches any number of directories, and a pattern without a slash matches
+// the base name at any depth. A pattern also matches everything below a
+// directory it matches, so "gen" and "gen/**" both exclude a gen directory.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	glob = strings.TrimPrefix(filepath.ToSlash(glob), "./")
+	glob = strings.TrimSuffix(glob, "/")
+	if !strings.Contains(glob, "/") {
+		glob = "**/" + glob
+	}
+	return globRegexp(glob)
+}
+
+// globRegexp translates a slash separated glob into a regular expression
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	if _, err := path.Match(strings.ReplaceAll(glob, "**", "*"), ""); err != nil {
+		return nil, err
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("(?:/.*)?$")
+	return regexp.Compile(expr.String())
+}
+
+func matchAny(patterns []*regexp.Regexp, rel string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,280 @@
+package parser
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Implementation records that a named type of the project satisfies an
+// interface of the project
+type Implementation struct {
+	TypePackage      string // Import path of the implementing type
+	TypeName         string
+	InterfacePackage string // Import path of the interface
+	InterfaceName    string
+
+	// PointerOnly is set when only *Type satisfies the interface, because
+	// some of the required methods have pointer receivers
+	PointerOnly bool
+}
+
+// namedType is a type declaration together with the methods declared on it
+// and the imports of its declaring file
+type namedType struct {
+	pkg     *Package
+	decl    TypeDecl
+	imports map[string]string
+	methods []Func
+}
+
+// methodSets computes and caches the method sets of the project's types
+type methodSets struct {
+	project    *RawProjectData
+	types      map[string]*namedType // "<import path>.<Type>" -> type
+	interfaces map[string]map[string]string
+	sets       map[string]map[string]string
+}
+
+// qualifierPattern matches package qualifiers such as "io." in "io.Reader"
+var qualifierPattern = regexp.MustCompile(`\b[A-Za-z_][A-Za-z0-9_]*\.`)
+
+// Implementations finds every pair of a non-interface type and an interface
+// of the project where the type's method set contains all methods of the
+// interface. Method sets follow the Go rules: value receivers belong to
+// both T and *T, pointer receivers only to *T, and methods of embedded
+// fields are promoted unless a shallower method of the same name shadows
+// them. Methods are matched by name and by their parameter and result types.
+// Interfaces that embed types outside the project, type sets or type
+// parameters cannot be checked and are skipped.
+func (p *RawProjectData) Implementations() []Implementation {
+	// Type information, when available, also covers interfaces embedding
+	// types from other modules
+	if p.typedImplementations != nil {
+		return p.typedImplementations
+	}
+
+	sets := &methodSets{
+		project:    p,
+		types:      make(map[string]*namedType),
+		interfaces: make(map[string]map[string]string),
+		sets:       make(map[string]map[string]string),
+	}
+
+	for _, pkg := range p.Packages {
+		for _, filePath := range pkg.Files {
+			fileData := p.Files[filePath]
+			for _, decl := range fileData.Types {
+				sets.types[funcID(pkg.ImportPath, "", decl.Name)] = &namedType{
+					pkg:     pkg,
+					decl:    decl,
+					imports: p.ImportNames(fileData),
+				}
+			}
+		}
+	}
+	for _, pkg := range p.Packages {
+		for _, filePath := range pkg.Files {
+			for _, method := range p.Files[filePath].Methods {
+				if method.Receiver == nil {
+					continue
+				}
+				if typ, ok := sets.types[funcID(pkg.ImportPath, "", method.Receiver.Type)]; ok {
+					typ.methods = append(typ.methods, method)
+				}
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(sets.types))
+	for id := range sets.types {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var implementations []Implementation
+	for _, ifaceID := range ids {
+		iface := sets.types[ifaceID]
+		if iface.decl.Kind != TypeKindInterface || iface.decl.TypeParams != "" {
+			continue
+		}
+		required, ok := sets.interfaceMethods(ifaceID, make(map[string]bool))
+		if !ok || len(required) == 0 {
+			continue
+		}
+
+		for _, typeID := range ids {
+			typ := sets.types[typeID]
+			if typ.decl.Kind == TypeKindInterface || typ.decl.Kind == TypeKindAlias {
+				continue
+			}
+
+			impl := Implementation{
+				TypePackage:      typ.pkg.ImportPath,
+				TypeName:         typ.decl.Name,
+				InterfacePackage: iface.pkg.ImportPath,
+				InterfaceName:    iface.decl.Name,
+			}
+			switch {
+			case containsMethods(sets.methodSet(typeID, false), required):
+			case containsMethods(sets.methodSet(typeID, true), required):
+				impl.PointerOnly = true
+			default:
+				continue
+			}
+			implementations = append(implementations, impl)
+		}
+	}
+
+	sortImplementations(implementations)
+	return implementations
+}
+
+// interfaceMethods returns the methods of an interface, including those of
+// embedded interfaces, mapped to their signatures. It reports false if the
+// interface embeds anything that cannot be resolved within the project.
+func (s *methodSets) interfaceMethods(id string, seen map[string]bool) (map[string]string, bool) {
+	if methods, ok := s.interfaces[id]; ok {
+		return methods, methods != nil
+	}
+	if seen[id] {
+		return map[string]string{}, true
+	}
+	seen[id] = true
+
+	iface := s.types[id]
+	methods := make(map[string]string)
+	for _, method := range iface.decl.Methods {
+		methods[method.Name] = methodSignature(method)
+	}
+	for _, embed := range iface.decl.Embeds {
+		// The predeclared error interface is the one embed that needs no lookup
+		if embed == "error" {
+			methods["Error"] = "()string"
+			continue
+		}
+
+		scope := callScope{pkg: iface.pkg, imports: iface.imports}
+		pkgPath, typeName := s.project.resolveTypeExpr(scope, embed)
+		embeddedID := funcID(pkgPath, "", typeName)
+		if pkgPath == "" || strings.ContainsAny(embed, "|~[") || s.types[embeddedID] == nil || s.types[embeddedID].decl.Kind != TypeKindInterface {
+			s.interfaces[id] = nil
+			return nil, false
+		}
+
+		embedded, ok := s.interfaceMethods(embeddedID, seen)
+		if !ok {
+			s.interfaces[id] = nil
+			return nil, false
+		}
+		for name, signature := range embedded {
+			methods[name] = signature
+		}
+	}
+
+	s.interfaces[id] = methods
+	return methods, true
+}
+
+// methodSet returns the method set of the type with the given id, or of a
+// pointer to it, mapped to the method signatures
+func (s *methodSets) methodSet(id string, pointer bool) map[string]string {
+	key := id
+	if pointer {
+		key = "*" + id
+	}
+	if set, ok := s.sets[key]; ok {
+		return set
+	}
+
+	// Walk the embedding tree breadth first: a name found at one depth hides
+	// the same name at deeper levels, and a name found twice at the same
+	// depth is ambiguous and not part of the method set
+	type level struct {
+		id      string
+		pointer bool // Methods with pointer receivers are included
+	}
+	set := make(map[string]string)
+	hidden := make(map[string]bool)
+	visited := make(map[string]bool)
+	current := []level{{id: id, pointer: pointer}}
+
+	for len(current) > 0 {
+		found := make(map[string][]string)
+		var next []level
+
+		for _, l := range current {
+			typ := s.types[l.id]
+			if typ == nil || visited[l.id] {
+				continue
+			}
+			visited[l.id] = true
+
+			if typ.decl.Kind == TypeKindInterface {
+				methods, _ := s.interfaceMethods(l.id, make(map[string]bool))
+				for name, signature := range methods {
+					found[name] = append(found[name], signature)
+				}
+				continue
+			}
+
+			for _, method := range typ.methods {
+				if l.pointer || !method.Receiver.Pointer {
+					found[method.Name] = append(found[method.Name], methodSignature(method))
+				}
+			}
+
+			scope := callScope{pkg: typ.pkg, imports: typ.imports}
+			for _, field := range typ.decl.Fields {
+				if !field.Embedded {
+					continue
+				}
+				pkgPath, typeName := s.project.resolveTypeExpr(scope, field.Type)
+				if pkgPath == "" {
+					continue
+				}
+				next = append(next, level{
+					id:      funcID(pkgPath, "", typeName),
+					pointer: l.pointer || strings.HasPrefix(field.Type, "*"),
+				})
+			}
+		}
+
+		for name, signatures := range found {
+			if hidden[name] {
+				continue
+			}
+			hidden[name] = true
+			if len(signatures) == 1 {
+				set[name] = signatures[0]
+			}
+		}
+		current = next
+	}
+
+	s.sets[key] = set
+	return set
+}
+
+// methodSignature renders the parameter and result types of a method with
+// names, package qualifiers and whitespace removed, so the same signature
+// compares equal whether it is written inside or outside its package
+func methodSignature(fn Func) string {
+	types := func(params []Param) string {
+		parts := make([]string, len(params))
+		for i, param := range params {
+			parts[i] = qualifierPattern.ReplaceAllString(strings.Join(strings.Fields(param.Type), ""), "")
+		}
+		return strings.Join(parts, ",")
+	}
+	return "(" + types(fn.Params) + ")" + types(fn.Results)
+}
+
+func containsMethods(set map[string]string, required map[string]string) bool {
+	for name, signature := range required {
+		if set[name] != signature {
+			return false
+		}
+	}
+	return true
+}
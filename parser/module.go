@@ -0,0 +1,300 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Module describes a Go module as declared by its go.mod file
+type Module struct {
+	Path      string
+	Dir       string // Directory containing go.mod, slash separated and relative to the project root in parsed projects
+	GoVersion string
+	Requires  []string // Module paths of all required modules, sorted
+}
+
+// Workspace describes the go.work file at the root of a project
+type Workspace struct {
+	Dir       string // Directory containing go.work, slash separated and relative to the project root in parsed projects
+	GoVersion string
+	Use       []string // Directories of the workspace modules as written in go.work, sorted
+}
+
+// Package groups the files of a single Go package
+type Package struct {
+	ImportPath string
+	Name       string
+	Dir        string   // Slash separated and relative to the project root
+	Module     string   // Path of the module containing the package, empty outside any module
+	Files      []string // File paths as used in RawProjectData.Files, sorted
+	Imports    []string // Unique import paths of all files, sorted
+}
+
+// ImportKind classifies an import path relative to the project
+type ImportKind string
+
+const (
+	ImportStdlib   ImportKind = "stdlib"
+	ImportInternal ImportKind = "internal" // A package of the project's own module
+	ImportExternal ImportKind = "external" // A third-party package
+)
+
+// ReadModule reads the go.mod file in dir. It returns nil without an error
+// if dir does not contain a go.mod file.
+func ReadModule(dir string) (*Module, error) {
+	goModPath := filepath.Join(dir, "go.mod")
+	data, err := os.ReadFile(goModPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading go.mod: %w", err)
+	}
+
+	file, err := modfile.ParseLax(goModPath, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing go.mod: %w", err)
+	}
+	if file.Module == nil {
+		return nil, fmt.Errorf("go.mod in %s has no module directive", dir)
+	}
+
+	module := &Module{
+		Path: file.Module.Mod.Path,
+		Dir:  dir,
+	}
+	if file.Go != nil {
+		module.GoVersion = file.Go.Version
+	}
+	for _, req := range file.Require {
+		module.Requires = append(module.Requires, req.Mod.Path)
+	}
+	sort.Strings(module.Requires)
+
+	return module, nil
+}
+
+// ReadWorkspace reads the go.work file in dir. It returns nil without an
+// error if dir does not contain a go.work file.
+func ReadWorkspace(dir string) (*Workspace, error) {
+	goWorkPath := filepath.Join(dir, "go.work")
+	data, err := os.ReadFile(goWorkPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading go.work: %w", err)
+	}
+
+	file, err := modfile.ParseWork(goWorkPath, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing go.work: %w", err)
+	}
+
+	workspace := &Workspace{Dir: dir}
+	if file.Go != nil {
+		workspace.GoVersion = file.Go.Version
+	}
+	for _, use := range file.Use {
+		workspace.Use = append(workspace.Use, use.Path)
+	}
+	sort.Strings(workspace.Use)
+
+	return workspace, nil
+}
+
+// ModuleByPath returns the project's module with the given module path, or
+// nil if there is none
+func (p *RawProjectData) ModuleByPath(modulePath string) *Module {
+	for _, module := range p.Modules {
+		if module.Path == modulePath {
+			return module
+		}
+	}
+	return nil
+}
+
+// ModuleOf returns the project's module providing an import path, or nil
+// for imports outside the project's modules. Nested module paths win over
+// the paths of their parent modules.
+func (p *RawProjectData) ModuleOf(importPath string) *Module {
+	var best *Module
+	for _, module := range p.Modules {
+		if importPath != module.Path && !strings.HasPrefix(importPath, module.Path+"/") {
+			continue
+		}
+		if best == nil || len(module.Path) > len(best.Path) {
+			best = module
+		}
+	}
+	return best
+}
+
+// moduleForDir returns the innermost module containing the slash separated,
+// project relative dir
+func (p *RawProjectData) moduleForDir(dir string) *Module {
+	var best *Module
+	for _, module := range p.Modules {
+		if module.Dir != "." && dir != module.Dir && !strings.HasPrefix(dir, module.Dir+"/") {
+			continue
+		}
+		if best == nil || len(module.Dir) > len(best.Dir) {
+			best = module
+		}
+	}
+	return best
+}
+
+// ModuleProject returns a view of the project restricted to the packages of
+// one module, for diagrams of a single module of a multi-module repository.
+// Imports of the other modules appear as external dependencies in the view.
+// It returns nil if the project has no module with that path.
+func (p *RawProjectData) ModuleProject(modulePath string) *RawProjectData {
+	module := p.ModuleByPath(modulePath)
+	if module == nil {
+		return nil
+	}
+
+	view := &RawProjectData{
+		Root:       p.Root,
+		ModulePath: module.Path,
+		Files:      make(map[string]*FileData),
+		Module:     module,
+		Modules:    []*Module{module},
+		Packages:   make(map[string]*Package),
+		Backend:    p.Backend,
+	}
+	for importPath, pkg := range p.Packages {
+		if pkg.Module != modulePath {
+			continue
+		}
+		view.Packages[importPath] = pkg
+		for _, filePath := range pkg.Files {
+			view.Files[filePath] = p.Files[filePath]
+		}
+	}
+	if p.typedImplementations != nil {
+		view.typedImplementations = []Implementation{}
+		for _, impl := range p.typedImplementations {
+			if view.Packages[impl.TypePackage] != nil && view.Packages[impl.InterfacePackage] != nil {
+				view.typedImplementations = append(view.typedImplementations, impl)
+			}
+		}
+	}
+	return view
+}
+
+// ClassifyImport reports whether an import path refers to the standard
+// library, a package of one of the project's modules or a third-party package
+func (p *RawProjectData) ClassifyImport(importPath string) ImportKind {
+	for _, module := range p.Modules {
+		if importPath == module.Path || strings.HasPrefix(importPath, module.Path+"/") {
+			return ImportInternal
+		}
+	}
+	if _, ok := p.Packages[importPath]; ok {
+		return ImportInternal
+	}
+
+	// Standard library paths never contain a dot in their first element
+	firstElem, _, _ := strings.Cut(importPath, "/")
+	if !strings.Contains(firstElem, ".") {
+		return ImportStdlib
+	}
+	return ImportExternal
+}
+
+// ExternalModule returns the module path providing an external import,
+// using the go.mod requirements when available and otherwise guessing from
+// the usual host/owner/repo layout
+func (p *RawProjectData) ExternalModule(importPath string) string {
+	best := ""
+	for _, module := range p.Modules {
+		for _, req := range module.Requires {
+			if (importPath == req || strings.HasPrefix(importPath, req+"/")) && len(req) > len(best) {
+				best = req
+			}
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	elems := strings.Split(importPath, "/")
+	if len(elems) > 3 {
+		elems = elems[:3]
+	}
+	return strings.Join(elems, "/")
+}
+
+// buildPackages groups the parsed files into packages and resolves their
+// import paths against the innermost module containing them
+func buildPackages(projectData *RawProjectData) {
+	projectData.Packages = make(map[string]*Package)
+
+	for filePath, fileData := range projectData.Files {
+		dir := path.Dir(filePath)
+		module := projectData.moduleForDir(dir)
+		importPath := packageImportPath(module, dir)
+		// External test packages live next to the package they test
+		if strings.HasSuffix(fileData.PackageName, "_test") {
+			importPath += "_test"
+		}
+
+		pkg, ok := projectData.Packages[importPath]
+		if !ok {
+			pkg = &Package{
+				ImportPath: importPath,
+				Name:       fileData.PackageName,
+				Dir:        dir,
+			}
+			if module != nil {
+				pkg.Module = module.Path
+			}
+			projectData.Packages[importPath] = pkg
+		}
+		pkg.Files = append(pkg.Files, filePath)
+		for _, imp := range fileData.Imports {
+			pkg.Imports = append(pkg.Imports, imp.Path)
+		}
+	}
+
+	for _, pkg := range projectData.Packages {
+		sort.Strings(pkg.Files)
+		pkg.Imports = sortedUnique(pkg.Imports)
+	}
+}
+
+// packageImportPath derives the import path of the package in the project
+// relative dir. Outside any module it is the directory itself.
+func packageImportPath(module *Module, dir string) string {
+	if module == nil {
+		return dir
+	}
+	rel := dir
+	if module.Dir != "." {
+		rel = strings.TrimPrefix(strings.TrimPrefix(dir, module.Dir), "/")
+	}
+	if rel == "" || rel == "." {
+		return module.Path
+	}
+	return path.Join(module.Path, rel)
+}
+
+func sortedUnique(values []string) []string {
+	sort.Strings(values)
+	unique := values[:0]
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,252 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Backend selects how a project is analyzed
+type Backend string
+
+const (
+	// BackendTreeSitter parses each file on its own. It works on any source
+	// tree but resolves references across files and packages heuristically.
+	BackendTreeSitter Backend = "tree-sitter"
+
+	// BackendPackages additionally type-checks the project with go/packages,
+	// which resolves calls and interface implementations exactly but needs
+	// a module that builds
+	BackendPackages Backend = "packages"
+)
+
+// ParseBackend validates a Backend value, defaulting to tree-sitter
+func ParseBackend(value string) (Backend, error) {
+	switch Backend(value) {
+	case "":
+		return BackendTreeSitter, nil
+	case BackendTreeSitter, BackendPackages:
+		return Backend(value), nil
+	}
+	return "", fmt.Errorf("invalid parser backend %q (expected tree-sitter or packages)", value)
+}
+
+// CallTarget is the callee of a call as resolved from type information
+type CallTarget struct {
+	PackagePath string
+	Receiver    string // Named receiver type for methods, including interface methods
+	Name        string
+}
+
+// typedPackageMode loads everything needed to resolve calls and method sets
+const typedPackageMode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+	packages.NeedImports | packages.NeedDeps | packages.NeedModule |
+	packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo
+
+// applyTypeInfo type-checks the project and records resolved call targets
+// and interface implementations on the tree-sitter results. It returns an
+// error without modifying projectData if the project does not type-check.
+func applyTypeInfo(ctx context.Context, projectData *RawProjectData, opts ParseOptions) error {
+	// Type-check the same platform and tags the files were selected for
+	target := opts.buildContext()
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode:    typedPackageMode,
+		Dir:     projectData.Root,
+		Env:     append(os.Environ(), "GOOS="+target.GOOS, "GOARCH="+target.GOARCH),
+	}
+	if !target.CgoEnabled {
+		cfg.Env = append(cfg.Env, "CGO_ENABLED=0")
+	}
+	if len(target.BuildTags) > 0 {
+		cfg.BuildFlags = []string{"-tags=" + strings.Join(target.BuildTags, ",")}
+	}
+
+	// "./..." stops at nested modules, so without a workspace that stitches
+	// them together every module is loaded on its own
+	dirs := []string{projectData.Root}
+	if projectData.Workspace == nil && len(projectData.Modules) > 1 {
+		dirs = dirs[:0]
+		for _, module := range projectData.Modules {
+			dirs = append(dirs, projectData.AbsPath(module.Dir))
+		}
+	}
+	var pkgs []*packages.Package
+	for _, dir := range dirs {
+		cfg.Dir = dir
+		loaded, err := packages.Load(cfg, "./...")
+		if err != nil {
+			return fmt.Errorf("error loading packages in %s: %w", dir, err)
+		}
+		pkgs = append(pkgs, loaded...)
+	}
+
+	var loadErrors []packages.Error
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		loadErrors = append(loadErrors, pkg.Errors...)
+	})
+	if len(loadErrors) > 0 {
+		return fmt.Errorf("project does not type-check: %v (and %d more errors)", loadErrors[0], len(loadErrors)-1)
+	}
+	if len(pkgs) == 0 {
+		return fmt.Errorf("no packages found in %s", projectData.Root)
+	}
+
+	// Index the call sites of the parsed files by position
+	type callSite struct {
+		file  string
+		start uint32
+		end   uint32
+	}
+	targets := make(map[callSite]*CallTarget)
+
+	for _, pkg := range pkgs {
+		for i, file := range pkg.Syntax {
+			if i >= len(pkg.CompiledGoFiles) {
+				break
+			}
+			filePath := pkg.CompiledGoFiles[i]
+			tokenFile := pkg.Fset.File(file.Pos())
+			ast.Inspect(file, func(node ast.Node) bool {
+				call, ok := node.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				if target := callTarget(pkg.TypesInfo, call); target != nil {
+					site := callSite{
+						file:  filePath,
+						start: uint32(tokenFile.Offset(call.Pos())),
+						end:   uint32(tokenFile.Offset(call.End())),
+					}
+					targets[site] = target
+				}
+				return true
+			})
+		}
+	}
+
+	for filePath, fileData := range projectData.Files {
+		absPath := projectData.AbsPath(filePath)
+		for _, funcs := range [][]Func{fileData.Functions, fileData.Methods} {
+			for i := range funcs {
+				for j := range funcs[i].Calls {
+					call := &funcs[i].Calls[j]
+					call.Target = targets[callSite{file: absPath, start: call.StartByte, end: call.EndByte}]
+				}
+			}
+		}
+	}
+
+	projectData.typedImplementations = typedImplementations(pkgs)
+	projectData.Backend = BackendPackages
+	return nil
+}
+
+// callTarget resolves the function or method called by a call expression,
+// or returns nil for builtins, conversions and calls of function values
+func callTarget(info *types.Info, call *ast.CallExpr) *CallTarget {
+	fun := ast.Unparen(call.Fun)
+	// Explicit instantiations such as f[int](x)
+	switch index := fun.(type) {
+	case *ast.IndexExpr:
+		fun = index.X
+	case *ast.IndexListExpr:
+		fun = index.X
+	}
+
+	var ident *ast.Ident
+	switch fun := fun.(type) {
+	case *ast.Ident:
+		ident = fun
+	case *ast.SelectorExpr:
+		ident = fun.Sel
+	default:
+		return nil
+	}
+
+	fn, ok := info.Uses[ident].(*types.Func)
+	if !ok || fn.Pkg() == nil {
+		return nil
+	}
+	fn = fn.Origin()
+
+	target := &CallTarget{PackagePath: fn.Pkg().Path(), Name: fn.Name()}
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		recvType := recv.Type()
+		if pointer, ok := recvType.(*types.Pointer); ok {
+			recvType = pointer.Elem()
+		}
+		named, ok := recvType.(*types.Named)
+		if !ok {
+			return nil // Method of an anonymous interface
+		}
+		target.Receiver = named.Obj().Name()
+	}
+	return target
+}
+
+// typedImplementations finds the interface implementations between the
+// named types declared by the loaded packages
+func typedImplementations(pkgs []*packages.Package) []Implementation {
+	var named []*types.TypeName
+	for _, pkg := range pkgs {
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			if typeName, ok := scope.Lookup(name).(*types.TypeName); ok && !typeName.IsAlias() {
+				named = append(named, typeName)
+			}
+		}
+	}
+
+	implementations := []Implementation{}
+	for _, ifaceName := range named {
+		iface, ok := ifaceName.Type().Underlying().(*types.Interface)
+		if !ok || !iface.IsMethodSet() || iface.NumMethods() == 0 {
+			continue
+		}
+		if typeParams := ifaceName.Type().(*types.Named).TypeParams(); typeParams.Len() > 0 {
+			continue
+		}
+
+		for _, typeName := range named {
+			typ := typeName.Type()
+			if types.IsInterface(typ) || typ.(*types.Named).TypeParams().Len() > 0 {
+				continue
+			}
+
+			impl := Implementation{
+				TypePackage:      typeName.Pkg().Path(),
+				TypeName:         typeName.Name(),
+				InterfacePackage: ifaceName.Pkg().Path(),
+				InterfaceName:    ifaceName.Name(),
+			}
+			switch {
+			case types.Implements(typ, iface):
+			case types.Implements(types.NewPointer(typ), iface):
+				impl.PointerOnly = true
+			default:
+				continue
+			}
+			implementations = append(implementations, impl)
+		}
+	}
+
+	sortImplementations(implementations)
+	return implementations
+}
+
+func sortImplementations(implementations []Implementation) {
+	sort.SliceStable(implementations, func(i, j int) bool {
+		a, b := implementations[i], implementations[j]
+		if a.TypePackage+"."+a.TypeName != b.TypePackage+"."+b.TypeName {
+			return a.TypePackage+"."+a.TypeName < b.TypePackage+"."+b.TypeName
+		}
+		return a.InterfacePackage+"."+a.InterfaceName < b.InterfacePackage+"."+b.InterfaceName
+	})
+}
@@ -2,72 +2,303 @@ package parser
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"go/build"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"sync/atomic"
 
 	sitter "github.com/smacker/go-tree-sitter"
 	"github.com/smacker/go-tree-sitter/golang"
+	"golang.org/x/sync/errgroup"
 )
 
 // RawProjectData represents the parsed structure of a Go project
 // with raw parse tree information instead of manually extracted data
 type RawProjectData struct {
-	Files map[string]*FileData // filepath -> parsed file data
+	Root       string               // Absolute directory of the project, which all paths are relative to
+	ModulePath string               // Path of the module at the project root, empty if there is none
+	Files      map[string]*FileData // Slash separated path relative to Root -> parsed file data
+	Module     *Module              // Module declared by the go.mod at the project root, nil if there is none
+	Modules    []*Module            // Every module of the project, including nested ones, sorted by directory
+	Workspace  *Workspace           // Workspace declared by a go.work at the project root, nil if there is none
+	Packages   map[string]*Package  // import path -> package
+	Backend    Backend              // Backend that produced the data
+
+	typedImplementations []Implementation // Set by BackendPackages
+}
+
+// ParseOptions controls how ParseGoProject analyzes a project
+type ParseOptions struct {
+	// Backend selects the analysis. BackendPackages falls back to
+	// BackendTreeSitter when the project does not type-check.
+	Backend Backend
+
+	// Cache, when set, is consulted before parsing each file and receives
+	// the results of files that had to be parsed
+	Cache *ParseCache
+
+	// Workers is the number of files parsed in parallel. Zero uses one
+	// worker per CPU.
+	Workers int
+
+	// Include restricts parsing to files whose slash separated path relative
+	// to the project matches one of these globs, e.g. "internal/**"
+	Include []string
+
+	// Exclude skips files and directories matching any of these globs, e.g.
+	// "**/*.pb.go" or "gen"
+	Exclude []string
+
+	// NoDefaultExcludes also parses vendor and testdata directories, hidden
+	// directories, test files, generated files and files ignored by git
+	NoDefaultExcludes bool
+
+	// GOOS and GOARCH select the target platform whose files are parsed,
+	// defaulting to the host platform. Files excluded by build constraints
+	// or by _GOOS and _GOARCH file name suffixes are skipped, so types with
+	// per-platform implementations are only parsed once.
+	GOOS   string
+	GOARCH string
+
+	// BuildTags are additional build tags considered satisfied
+	BuildTags []string
+
+	// Logger receives progress messages, nil uses slog's default logger
+	Logger *slog.Logger
+}
+
+// logger returns the configured logger or slog's default logger
+func (opts ParseOptions) logger() *slog.Logger {
+	if opts.Logger == nil {
+		return slog.Default()
+	}
+	return opts.Logger
 }
 
-// FileData represents a parsed Go file with its raw content and tree
+// buildContext returns the go/build context of the target platform. As with
+// the go command, cgo is disabled when cross-compiling.
+func (opts ParseOptions) buildContext() build.Context {
+	ctx := build.Default
+	if opts.GOOS != "" {
+		ctx.GOOS = opts.GOOS
+	}
+	if opts.GOARCH != "" {
+		ctx.GOARCH = opts.GOARCH
+	}
+	if ctx.GOOS != build.Default.GOOS || ctx.GOARCH != build.Default.GOARCH {
+		ctx.CgoEnabled = false
+	}
+	ctx.BuildTags = opts.BuildTags
+	return ctx
+}
+
+// FileData represents a parsed Go file with its raw content, tree and
+// the symbols extracted from it
 type FileData struct {
 	Content     string
 	PackageName string
 	ParseTree   string // Serialized parse tree
+
+	Imports   []Import
+	Types     []TypeDecl
+	Functions []Func // Top-level functions
+	Methods   []Func // Methods declared with a receiver
+	Consts    []Value
+	Vars      []Value
 }
 
-// ParseGoProject parses a Go project directory and returns raw data
-func ParseGoProject(projectPath string) (*RawProjectData, error) {
+// ParseGoProject parses a Go project directory, or a single Go file, and
+// returns raw data. File, package and module paths in the result are
+// relative to the project, so the data does not depend on where the project
+// was checked out. It stops early with ctx's error when ctx is cancelled.
+func ParseGoProject(ctx context.Context, projectPath string, opts ParseOptions) (*RawProjectData, error) {
+	projectPath, err := filepath.Abs(projectPath)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving project directory: %w", err)
+	}
+
+	// The go.mod of a single-file project is looked up next to the file
+	root := projectPath
+	if info, err := os.Stat(projectPath); err == nil && !info.IsDir() {
+		root = filepath.Dir(projectPath)
+	}
 	projectData := &RawProjectData{
-		Files: make(map[string]*FileData),
+		Root:    root,
+		Files:   make(map[string]*FileData),
+		Backend: BackendTreeSitter,
 	}
 
-	fmt.Println("projectPath", projectPath)
+	filter, err := newFileFilter(projectPath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file pattern: %w", err)
+	}
 
-	// Walk through the project directory
-	err := filepath.Walk(projectPath, func(path string, info os.FileInfo, err error) error {
+	// Collect the Go files first, so they can be parsed in parallel
+	var paths []string
+	err = filepath.Walk(projectPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		// Skip directories and non-Go files
-		if info.IsDir() || filepath.Ext(path) != ".go" {
+		if info.IsDir() {
+			if filter.skipDir(path) {
+				return filepath.SkipDir
+			}
+			// Nested modules of a monorepo; the root module is read below
+			if path != projectPath {
+				module, err := ReadModule(path)
+				if err != nil {
+					opts.logger().Warn("Skipping module", "dir", projectData.RelPath(path), "error", err)
+				} else if module != nil {
+					module.Dir = projectData.RelPath(path)
+					projectData.Modules = append(projectData.Modules, module)
+				}
+			}
 			return nil
 		}
 
-		// Parse the Go file with tree-sitter
-		fileData, err := parseGoFile(path)
-		if err != nil {
-			return fmt.Errorf("error parsing file %s: %w", path, err)
+		// Skip non-Go files and files filtered out
+		if filepath.Ext(path) != ".go" || !filter.keepFile(path) {
+			return nil
 		}
-
-		// Store the raw file data
-		projectData.Files[path] = fileData
-		fmt.Printf("Added file: %s\n", path)
-
+		paths = append(paths, path)
 		return nil
 	})
 
 	if err != nil {
 		return nil, fmt.Errorf("error walking project directory: %w", err)
 	}
-	//project data to string
-	projectDataString, err := json.Marshal(projectData)
+
+	files, cached, err := parseFiles(ctx, paths, opts)
 	if err != nil {
-		return nil, fmt.Errorf("error marshalling project data: %w", err)
+		return nil, err
 	}
-	fmt.Println("Parsed project data", string(projectDataString))
+	for i, path := range paths {
+		// Store the raw file data
+		rel := projectData.RelPath(path)
+		projectData.Files[rel] = files[i]
+		opts.logger().Debug("Parsed file", "path", rel)
+	}
+	opts.logger().Info("Parsed Go files", "files", len(paths), "cached", cached)
+
+	// Resolve packages against go.mod
+	projectData.Module, err = ReadModule(root)
+	if err != nil {
+		return nil, err
+	}
+	if projectData.Module != nil {
+		projectData.Module.Dir = "."
+		projectData.ModulePath = projectData.Module.Path
+		projectData.Modules = append(projectData.Modules, projectData.Module)
+	}
+	sort.Slice(projectData.Modules, func(i, j int) bool {
+		return projectData.Modules[i].Dir < projectData.Modules[j].Dir
+	})
+	projectData.Workspace, err = ReadWorkspace(root)
+	if err != nil {
+		return nil, err
+	}
+	if projectData.Workspace != nil {
+		projectData.Workspace.Dir = "."
+	}
+	buildPackages(projectData)
+
+	if opts.Backend == BackendPackages {
+		if err := applyTypeInfo(ctx, projectData, opts); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			opts.logger().Warn("Type-checked analysis unavailable, falling back to tree-sitter", "error", err)
+		}
+	}
+	opts.logger().Debug("Resolved packages", "packages", len(projectData.Packages), "modules", len(projectData.Modules))
 	return projectData, nil
 }
 
+// RelPath returns the slash separated path of a file or directory relative
+// to the project root, "." for the root itself
+func (p *RawProjectData) RelPath(absPath string) string {
+	rel, err := filepath.Rel(p.Root, absPath)
+	if err != nil {
+		return filepath.ToSlash(absPath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// AbsPath returns the absolute path of a path relative to the project root
+func (p *RawProjectData) AbsPath(relPath string) string {
+	return filepath.Join(p.Root, filepath.FromSlash(relPath))
+}
+
+// parseFiles parses the given files on a pool of workers, each with its own
+// tree-sitter parser, and returns the results in the order of paths together
+// with the number of files loaded from the cache. The first error cancels
+// the remaining work.
+func parseFiles(ctx context.Context, paths []string, opts ParseOptions) ([]*FileData, int, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(paths))
+
+	files := make([]*FileData, len(paths))
+	var cached atomic.Int64
+	jobs := make(chan int)
+
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		defer close(jobs)
+		for i := range paths {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	for w := 0; w < workers; w++ {
+		group.Go(func() error {
+			sitterParser := sitter.NewParser()
+			defer sitterParser.Close()
+			sitterParser.SetLanguage(golang.GetLanguage())
+
+			for i := range jobs {
+				content, err := os.ReadFile(paths[i])
+				if err != nil {
+					return fmt.Errorf("error reading file %s: %w", paths[i], err)
+				}
+
+				// Parse the Go file with tree-sitter unless it is unchanged since it was cached
+				if fileData, ok := opts.Cache.lookup(content); ok {
+					files[i] = fileData
+					cached.Add(1)
+					continue
+				}
+				fileData, err := parseGoSource(ctx, sitterParser, content)
+				if err != nil {
+					return fmt.Errorf("error parsing file %s: %w", paths[i], err)
+				}
+				opts.Cache.store(content, fileData, opts.logger())
+				files[i] = fileData
+			}
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, 0, err
+	}
+	return files, int(cached.Load()), nil
+}
+
 // parseGoFile parses a single Go file using Tree-sitter
 func parseGoFile(filePath string) (*FileData, error) {
 	// Read the file
@@ -80,20 +311,22 @@ func parseGoFile(filePath string) (*FileData, error) {
 	parser := sitter.NewParser()
 	parser.SetLanguage(golang.GetLanguage())
 
-	// Create a context and parse the file
-	ctx := context.Background()
+	return parseGoSource(context.Background(), parser, content)
+}
+
+// parseGoSource parses the content of a Go file with the given parser
+func parseGoSource(ctx context.Context, parser *sitter.Parser, content []byte) (*FileData, error) {
 	tree, err := parser.ParseCtx(ctx, nil, content)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing with tree-sitter: %w", err)
 	}
-	fmt.Printf("tree: %v\n", tree)
 	defer tree.Close()
 
 	// Extract package name for basic information
 	packageName := ""
 	packageNode := findFirstNodeOfType(tree.RootNode(), "package_clause")
 	if packageNode != nil {
-		identifierNode := findFirstNodeOfType(packageNode, "identifier")
+		identifierNode := findFirstNodeOfType(packageNode, "package_identifier")
 		if identifierNode != nil {
 			packageName = string(content[identifierNode.StartByte():identifierNode.EndByte()])
 		}
@@ -105,6 +338,7 @@ func parseGoFile(filePath string) (*FileData, error) {
 		PackageName: packageName,
 		ParseTree:   tree.RootNode().String(),
 	}
+	extractSymbols(tree.RootNode(), content, fileData)
 
 	return fileData, nil
 }
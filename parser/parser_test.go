@@ -1,11 +1,32 @@
 package parser
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 )
 
+// writeTestProject writes files, keyed by slash separated paths, into a
+// temporary directory and returns it
+func writeTestProject(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
 func TestParseGoFile(t *testing.T) {
 	// Create a temporary Go file for testing
 	tmpDir, err := os.MkdirTemp("", "parser-test-")
@@ -129,7 +150,7 @@ func (s *Service) Name() string {
 	}
 
 	// Parse the project
-	projectData, err := ParseGoProject(tmpDir)
+	projectData, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{})
 	if err != nil {
 		t.Fatalf("Failed to parse project: %v", err)
 	}
@@ -139,9 +160,13 @@ func (s *Service) Name() string {
 		t.Errorf("Expected 2 files, got %d", len(projectData.Files))
 	}
 
+	// Files are keyed by their slash separated path relative to the project
+	if projectData.Root != tmpDir {
+		t.Errorf("Expected root %s, got %s", tmpDir, projectData.Root)
+	}
+
 	// Check for the main.go file
-	mainFilePath := filepath.Join(tmpDir, "main.go")
-	mainFileData, exists := projectData.Files[mainFilePath]
+	mainFileData, exists := projectData.Files["main.go"]
 	if !exists {
 		t.Error("Main file not found")
 	} else if mainFileData.PackageName != "main" {
@@ -149,11 +174,839 @@ func (s *Service) Name() string {
 	}
 
 	// Check for the service.go file
-	serviceFilePath := filepath.Join(pkgDir, "service.go")
-	serviceFileData, exists := projectData.Files[serviceFilePath]
+	serviceFileData, exists := projectData.Files["pkg/service.go"]
 	if !exists {
 		t.Error("Service file not found")
 	} else if serviceFileData.PackageName != "pkg" {
 		t.Errorf("Expected package 'pkg', got '%s'", serviceFileData.PackageName)
 	}
 }
+
+func TestParseGoFileSymbols(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "parser-test-")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sampleCode := `package shapes
+
+import (
+	"fmt"
+	str "strings"
+)
+
+// Shape is anything with an area
+type Shape interface {
+	fmt.Stringer
+	Area() (float64, error)
+}
+
+// Square is a shape
+type Square struct {
+	Base
+	*Style
+	Side, Depth float64 ` + "`json:\"side\"`" + `
+	labels      []string
+}
+
+type Unit = float64
+
+// Scale is a factor
+type Scale int
+
+const Pi, E = 3.14, 2.71
+
+var (
+	defaultSide = 1.0
+	names       []string
+)
+
+// Area returns the area
+func (s *Square) Area() (float64, error) {
+	return s.Side * s.Side, nil
+}
+
+func (Square) String() string { return str.ToUpper("square") }
+
+// NewSquare creates a square
+func NewSquare(side float64, opts ...string) *Square {
+	return &Square{Side: side}
+}
+`
+
+	filePath := filepath.Join(tmpDir, "shapes.go")
+	if err := os.WriteFile(filePath, []byte(sampleCode), 0644); err != nil {
+		t.Fatalf("Failed to write sample file: %v", err)
+	}
+
+	fileData, err := parseGoFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to parse Go file: %v", err)
+	}
+
+	// Imports
+	if len(fileData.Imports) != 2 {
+		t.Fatalf("Expected 2 imports, got %d", len(fileData.Imports))
+	}
+	if imp := fileData.Imports[1]; imp.Name != "str" || imp.Path != "strings" || imp.StartLine != 5 {
+		t.Errorf("Unexpected second import: %+v", imp)
+	}
+
+	// Types
+	if len(fileData.Types) != 4 {
+		t.Fatalf("Expected 4 types, got %d", len(fileData.Types))
+	}
+	shape := fileData.Types[0]
+	if shape.Name != "Shape" || shape.Kind != TypeKindInterface || shape.Doc != "Shape is anything with an area" {
+		t.Errorf("Unexpected interface declaration: %+v", shape)
+	}
+	if len(shape.Embeds) != 1 || shape.Embeds[0] != "fmt.Stringer" {
+		t.Errorf("Expected embedded fmt.Stringer, got %v", shape.Embeds)
+	}
+	if len(shape.Methods) != 1 || shape.Methods[0].Name != "Area" || len(shape.Methods[0].Results) != 2 {
+		t.Errorf("Unexpected interface methods: %+v", shape.Methods)
+	}
+
+	square := fileData.Types[1]
+	if square.Kind != TypeKindStruct || len(square.Fields) != 5 {
+		t.Fatalf("Expected struct with 5 fields, got %+v", square)
+	}
+	if f := square.Fields[1]; !f.Embedded || f.Name != "Style" || f.Type != "*Style" {
+		t.Errorf("Unexpected embedded field: %+v", f)
+	}
+	if f := square.Fields[3]; f.Name != "Depth" || f.Type != "float64" || f.Tag != "`json:\"side\"`" {
+		t.Errorf("Unexpected tagged field: %+v", f)
+	}
+	if alias := fileData.Types[2]; alias.Kind != TypeKindAlias || alias.Underlying != "float64" {
+		t.Errorf("Unexpected alias: %+v", alias)
+	}
+	if named := fileData.Types[3]; named.Kind != TypeKindNamed || named.Underlying != "int" || named.Doc != "Scale is a factor" {
+		t.Errorf("Unexpected named type: %+v", named)
+	}
+
+	// Methods and functions
+	if len(fileData.Methods) != 2 {
+		t.Fatalf("Expected 2 methods, got %d", len(fileData.Methods))
+	}
+	area := fileData.Methods[0]
+	if area.Receiver == nil || area.Receiver.Type != "Square" || !area.Receiver.Pointer || area.Receiver.Name != "s" {
+		t.Errorf("Unexpected receiver: %+v", area.Receiver)
+	}
+	if area.StartLine != 35 || area.EndLine != 37 {
+		t.Errorf("Expected Area on lines 35-37, got %d-%d", area.StartLine, area.EndLine)
+	}
+	if recv := fileData.Methods[1].Receiver; recv == nil || recv.Pointer || recv.Name != "" {
+		t.Errorf("Unexpected value receiver: %+v", recv)
+	}
+	if len(fileData.Functions) != 1 {
+		t.Fatalf("Expected 1 function, got %d", len(fileData.Functions))
+	}
+	newSquare := fileData.Functions[0]
+	if len(newSquare.Params) != 2 || newSquare.Params[1].Type != "...string" {
+		t.Errorf("Unexpected params: %+v", newSquare.Params)
+	}
+	if len(newSquare.Results) != 1 || newSquare.Results[0].Type != "*Square" {
+		t.Errorf("Unexpected results: %+v", newSquare.Results)
+	}
+	if got := sampleCode[newSquare.StartByte:newSquare.EndByte]; !strings.HasPrefix(got, "func NewSquare") {
+		t.Errorf("Byte range does not cover the declaration: %q", got)
+	}
+
+	// Consts and vars
+	if len(fileData.Consts) != 2 || fileData.Consts[1].Name != "E" || fileData.Consts[1].Value != "2.71" {
+		t.Errorf("Unexpected consts: %+v", fileData.Consts)
+	}
+	if len(fileData.Vars) != 2 || fileData.Vars[1].Type != "[]string" {
+		t.Errorf("Unexpected vars: %+v", fileData.Vars)
+	}
+}
+
+func TestParseGoProjectPackages(t *testing.T) {
+	files := map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire github.com/spf13/cobra v1.8.0\n",
+		"main.go": `package main
+
+import (
+	"fmt"
+
+	"example.com/app/internal/store"
+	"github.com/spf13/cobra/doc"
+)
+`,
+		"internal/store/store.go": "package store\n\nimport \"os\"\n",
+	}
+	tmpDir := writeTestProject(t, files)
+
+	projectData, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+
+	if projectData.Module == nil || projectData.Module.Path != "example.com/app" || projectData.Module.GoVersion != "1.22" {
+		t.Fatalf("Unexpected module: %+v", projectData.Module)
+	}
+
+	mainPkg, ok := projectData.Packages["example.com/app"]
+	if !ok {
+		t.Fatalf("Root package not found, got %v", projectData.Packages)
+	}
+	expectedImports := []string{"example.com/app/internal/store", "fmt", "github.com/spf13/cobra/doc"}
+	if strings.Join(mainPkg.Imports, ",") != strings.Join(expectedImports, ",") {
+		t.Errorf("Expected imports %v, got %v", expectedImports, mainPkg.Imports)
+	}
+	if _, ok := projectData.Packages["example.com/app/internal/store"]; !ok {
+		t.Errorf("Store package not found")
+	}
+
+	kinds := map[string]ImportKind{
+		"example.com/app/internal/store": ImportInternal,
+		"fmt":                            ImportStdlib,
+		"net/http":                       ImportStdlib,
+		"github.com/spf13/cobra/doc":     ImportExternal,
+	}
+	for importPath, want := range kinds {
+		if got := projectData.ClassifyImport(importPath); got != want {
+			t.Errorf("ClassifyImport(%q) = %s, want %s", importPath, got, want)
+		}
+	}
+
+	if got := projectData.ExternalModule("github.com/spf13/cobra/doc"); got != "github.com/spf13/cobra" {
+		t.Errorf("Expected required module github.com/spf13/cobra, got %s", got)
+	}
+	if got := projectData.ExternalModule("golang.org/x/tools/go/packages"); got != "golang.org/x/tools" {
+		t.Errorf("Expected guessed module golang.org/x/tools, got %s", got)
+	}
+}
+
+func TestCallGraph(t *testing.T) {
+	files := map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n",
+		"app.go": `package app
+
+import (
+	"net/http"
+
+	db "example.com/app/storage"
+)
+
+type Base struct{}
+
+func (Base) Close() error { return nil }
+
+type Server struct {
+	Base
+	repo *db.Repo
+}
+
+func (s *Server) Handle(w http.ResponseWriter, r *db.Repo) {
+	s.repo.Save()
+	r.Save()
+	s.Close()
+	w.WriteHeader(200)
+	helper(db.New())
+	unknown.Call()
+}
+
+func helper(r *db.Repo) {}
+`,
+		"storage/repo.go": `package storage
+
+type Repo struct{}
+
+func New() *Repo { return &Repo{} }
+
+func (r *Repo) Save() {}
+`,
+	}
+	tmpDir := writeTestProject(t, files)
+
+	projectData, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+
+	graph := projectData.CallGraph()
+	handle, err := graph.FindFunc("app.Server.Handle")
+	if err != nil {
+		t.Fatalf("Failed to find entry: %v", err)
+	}
+
+	var callees []string
+	for _, call := range handle.Calls {
+		callees = append(callees, call.Callee+"/"+string(call.Kind))
+	}
+	expected := []string{
+		"example.com/app/storage.Repo.Save/internal", // Field chain
+		"example.com/app/storage.Repo.Save/internal", // Parameter
+		"example.com/app.Base.Close/internal",        // Promoted from embedded struct
+		"net/http.ResponseWriter.WriteHeader/stdlib", // Method on an external type
+		"example.com/app/storage.New/internal",       // Aliased import, evaluated before helper
+		"example.com/app.helper/internal",
+	}
+	if strings.Join(callees, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected calls:\n%s\nwant:\n%s", strings.Join(callees, "\n"), strings.Join(expected, "\n"))
+	}
+
+	if _, err := graph.FindFunc("example.com/app/storage.Repo.Save"); err != nil {
+		t.Errorf("Expected lookup by full id to succeed: %v", err)
+	}
+	if _, err := graph.FindFunc("app.Missing"); err == nil {
+		t.Error("Expected an error for an unknown function")
+	}
+}
+
+func TestImplementations(t *testing.T) {
+	files := map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n",
+		"io/io.go": `package io
+
+type Reader interface {
+	Read(p []byte) (int, error)
+}
+
+type Closer interface {
+	Close() error
+}
+
+type ReadCloser interface {
+	Reader
+	Closer
+}
+
+type Named interface {
+	error
+	Name() string
+}
+
+type Numeric interface {
+	~int | ~float64
+}
+`,
+		"file/file.go": `package file
+
+import "example.com/app/io"
+
+type File struct{}
+
+func (f *File) Read(buf []byte) (n int, err error) { return 0, nil }
+
+func (f File) Close() error { return nil }
+
+// Wrapper gets Read and Close from the embedded pointer
+type Wrapper struct {
+	*File
+}
+
+// Shadowed hides File's Read behind a method with another signature
+type Shadowed struct {
+	File
+}
+
+func (Shadowed) Read() {}
+
+// Stream embeds an interface, whose methods are promoted
+type Stream struct {
+	io.Reader
+}
+
+func (Stream) Close() error { return nil }
+
+type Problem string
+
+func (Problem) Error() string { return "" }
+
+func (Problem) Name() string { return "" }
+`,
+	}
+	tmpDir := writeTestProject(t, files)
+
+	projectData, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+
+	var actual []string
+	for _, impl := range projectData.Implementations() {
+		line := strings.TrimPrefix(impl.TypePackage, "example.com/app/") + "." + impl.TypeName +
+			" -> " + strings.TrimPrefix(impl.InterfacePackage, "example.com/app/") + "." + impl.InterfaceName
+		if impl.PointerOnly {
+			line += " (pointer)"
+		}
+		actual = append(actual, line)
+	}
+	expected := []string{
+		"file.File -> io.Closer",
+		"file.File -> io.ReadCloser (pointer)",
+		"file.File -> io.Reader (pointer)",
+		"file.Problem -> io.Named",
+		"file.Shadowed -> io.Closer",
+		"file.Stream -> io.Closer",
+		"file.Stream -> io.ReadCloser",
+		"file.Stream -> io.Reader",
+		"file.Wrapper -> io.Closer",
+		"file.Wrapper -> io.ReadCloser",
+		"file.Wrapper -> io.Reader",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected implementations:\n%s\nwant:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestParseGoProjectPackagesBackend(t *testing.T) {
+	files := map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n",
+		"app.go": `package app
+
+import (
+	"fmt"
+
+	store "example.com/app/storage"
+)
+
+type Saver interface {
+	Save() error
+}
+
+func Run() {
+	repo := store.New()
+	repo.Save()
+	fmt.Println(First([]int{1}))
+	var s Saver = repo
+	s.Save()
+}
+
+func First[T any](items []T) T { return items[0] }
+`,
+		"storage/repo.go": `package storage
+
+type Repo struct{}
+
+func New() *Repo { return &Repo{} }
+
+func (r *Repo) Save() error { return nil }
+`,
+	}
+	tmpDir := writeTestProject(t, files)
+
+	projectData, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{Backend: BackendPackages})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+	if projectData.Backend != BackendPackages {
+		t.Fatalf("Expected the packages backend, got %s", projectData.Backend)
+	}
+
+	run, err := projectData.CallGraph().FindFunc("app.Run")
+	if err != nil {
+		t.Fatalf("Failed to find entry: %v", err)
+	}
+	var callees []string
+	for _, call := range run.Calls {
+		callees = append(callees, call.Callee)
+	}
+	// The local variable and the generic call can only be resolved with type
+	// information; the call through the project interface has no body
+	expected := []string{
+		"example.com/app/storage.New",
+		"example.com/app/storage.Repo.Save",
+		"example.com/app.First",
+		"fmt.Println",
+	}
+	if strings.Join(callees, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected calls:\n%s\nwant:\n%s", strings.Join(callees, "\n"), strings.Join(expected, "\n"))
+	}
+
+	implementations := projectData.Implementations()
+	if len(implementations) != 1 || implementations[0].TypeName != "Repo" || !implementations[0].PointerOnly {
+		t.Errorf("Expected *Repo to implement Saver, got %+v", implementations)
+	}
+
+	// A project that does not type-check falls back to tree-sitter
+	if err := os.WriteFile(filepath.Join(tmpDir, "broken.go"), []byte("package app\n\nvar x int = \"text\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write broken.go: %v", err)
+	}
+	projectData, err = ParseGoProject(context.Background(), tmpDir, ParseOptions{Backend: BackendPackages})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+	if projectData.Backend != BackendTreeSitter {
+		t.Errorf("Expected fallback to tree-sitter, got %s", projectData.Backend)
+	}
+}
+
+func TestParseCache(t *testing.T) {
+	files := map[string]string{
+		"go.mod":  "module example.com/app\n\ngo 1.22\n",
+		"app.go":  "package app\n\ntype Repo struct{ Name string }\n\nfunc (r *Repo) Save() error { return nil }\n",
+		"main.go": "package app\n\nfunc Run() { (&Repo{}).Save() }\n",
+	}
+	tmpDir := writeTestProject(t, files)
+
+	cache := &ParseCache{Dir: t.TempDir()}
+	first, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{Cache: cache})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+
+	// The second run loads every file from the cache with identical results
+	appPath := filepath.Join(tmpDir, "app.go")
+	content, _ := os.ReadFile(appPath)
+	cachedData, ok := cache.Get(content)
+	if !ok {
+		t.Fatal("Expected app.go to be cached")
+	}
+	if cachedData.Content != string(content) || len(cachedData.Types) != 1 || cachedData.Types[0].Fields[0].Name != "Name" {
+		t.Errorf("Unexpected cached data: %+v", cachedData)
+	}
+
+	second, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{Cache: cache})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+	for path, fileData := range first.Files {
+		cachedFile := second.Files[path]
+		if cachedFile == nil || cachedFile.ParseTree != fileData.ParseTree || len(cachedFile.Methods) != len(fileData.Methods) ||
+			len(cachedFile.Functions) != len(fileData.Functions) {
+			t.Errorf("Cached parse of %s differs from the original", path)
+		}
+	}
+
+	// Changed files miss the cache and are parsed again
+	changed := "package app\n\ntype Repo struct{ ID int }\n"
+	if err := os.WriteFile(appPath, []byte(changed), 0644); err != nil {
+		t.Fatalf("Failed to write app.go: %v", err)
+	}
+	third, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{Cache: cache})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+	if fields := third.Files["app.go"].Types[0].Fields; len(fields) != 1 || fields[0].Name != "ID" {
+		t.Errorf("Expected the changed file to be parsed again, got %+v", fields)
+	}
+
+	if err := cache.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if _, ok := cache.Get(content); ok {
+		t.Error("Expected an empty cache after Clear")
+	}
+}
+
+// writeBenchmarkProject writes a module of generated packages for the parsing benchmarks
+func writeBenchmarkProject(tb testing.TB, packages, filesPerPackage int) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/bench\n\ngo 1.22\n"), 0644); err != nil {
+		tb.Fatalf("Failed to write go.mod: %v", err)
+	}
+	for p := 0; p < packages; p++ {
+		pkgDir := filepath.Join(dir, fmt.Sprintf("pkg%d", p))
+		if err := os.MkdirAll(pkgDir, 0755); err != nil {
+			tb.Fatalf("Failed to create package directory: %v", err)
+		}
+		for f := 0; f < filesPerPackage; f++ {
+			var src strings.Builder
+			fmt.Fprintf(&src, "package pkg%d\n\nimport \"fmt\"\n\n", p)
+			for t := 0; t < 20; t++ {
+				fmt.Fprintf(&src, "type T%d_%d struct {\n\tName string\n\tNext *T%d_%d\n}\n\n", f, t, f, t)
+				fmt.Fprintf(&src, "func (v *T%d_%d) Describe(prefix string) string {\n\treturn fmt.Sprintf(\"%%s %%s\", prefix, v.Name)\n}\n\n", f, t)
+			}
+			if err := os.WriteFile(filepath.Join(pkgDir, fmt.Sprintf("file%d.go", f)), []byte(src.String()), 0644); err != nil {
+				tb.Fatalf("Failed to write file: %v", err)
+			}
+		}
+	}
+	return dir
+}
+
+func TestParseFilesConcurrently(t *testing.T) {
+	dir := writeBenchmarkProject(t, 4, 5)
+	var paths []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && filepath.Ext(path) == ".go" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	sequential, _, err := parseFiles(context.Background(), paths, ParseOptions{Workers: 1})
+	if err != nil {
+		t.Fatalf("Sequential parse failed: %v", err)
+	}
+	concurrent, _, err := parseFiles(context.Background(), paths, ParseOptions{Workers: 8})
+	if err != nil {
+		t.Fatalf("Concurrent parse failed: %v", err)
+	}
+	for i, path := range paths {
+		if concurrent[i].Content != sequential[i].Content || concurrent[i].ParseTree != sequential[i].ParseTree {
+			t.Errorf("Result %d for %s is out of order or differs from the sequential parse", i, path)
+		}
+	}
+
+	// A missing file fails the whole parse
+	if _, _, err := parseFiles(context.Background(), append(paths, filepath.Join(dir, "missing.go")), ParseOptions{Workers: 4}); err == nil || !strings.Contains(err.Error(), "missing.go") {
+		t.Errorf("Expected an error for the missing file, got %v", err)
+	}
+
+	// Cancellation stops the workers
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := parseFiles(ctx, paths, ParseOptions{Workers: 4}); err == nil {
+		t.Error("Expected an error for a cancelled context")
+	}
+}
+
+func BenchmarkParseFiles(b *testing.B) {
+	dir := writeBenchmarkProject(b, 10, 10)
+	var paths []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && filepath.Ext(path) == ".go" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	for _, bench := range []struct {
+		name    string
+		workers int
+	}{
+		{"sequential", 1},
+		{"workers", 0},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := parseFiles(context.Background(), paths, ParseOptions{Workers: bench.workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseGoProjectFileFilter(t *testing.T) {
+	files := map[string]string{
+		"go.mod":                "module example.com/app\n\ngo 1.22\n",
+		".gitignore":            "# build output\n/build/\n",
+		"main.go":               "package main\n",
+		"main_test.go":          "package main\n",
+		"vendor/lib/lib.go":     "package lib\n",
+		"testdata/fixture.go":   "package fixture\n",
+		".hidden/hidden.go":     "package hidden\n",
+		"_tools/tools.go":       "package tools\n",
+		"build/out.go":          "package out\n",
+		"api/api.go":            "package api\n",
+		"api/api.pb.go":         "// Code generated by protoc-gen-go. DO NOT EDIT.\n// source: api.proto\n\npackage api\n",
+		"api/.gitignore":        "*_mock.go\n!keep_mock.go\n",
+		"api/service_mock.go":   "package api\n",
+		"api/keep_mock.go":      "package api\n",
+		"api/build/build.go":    "package build\n",
+		"internal/store/db.go":  "package store\n",
+		"internal/store/db.sql": "SELECT 1;\n",
+	}
+	tmpDir := writeTestProject(t, files)
+
+	parsedFiles := func(opts ParseOptions) []string {
+		t.Helper()
+		projectData, err := ParseGoProject(context.Background(), tmpDir, opts)
+		if err != nil {
+			t.Fatalf("Failed to parse project: %v", err)
+		}
+		var names []string
+		for path := range projectData.Files {
+			names = append(names, path)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	tests := []struct {
+		name     string
+		opts     ParseOptions
+		expected []string
+	}{
+		{
+			// The anchored /build/ only ignores the top-level directory
+			name:     "defaults",
+			opts:     ParseOptions{},
+			expected: []string{"api/api.go", "api/build/build.go", "api/keep_mock.go", "internal/store/db.go", "main.go"},
+		},
+		{
+			name:     "exclude",
+			opts:     ParseOptions{Exclude: []string{"internal", "api/build/**", "*_mock.go"}},
+			expected: []string{"api/api.go", "main.go"},
+		},
+		{
+			name:     "include",
+			opts:     ParseOptions{Include: []string{"api/*.go"}},
+			expected: []string{"api/api.go", "api/keep_mock.go"},
+		},
+		{
+			name: "no defaults",
+			opts: ParseOptions{NoDefaultExcludes: true, Exclude: []string{"vendor"}},
+			expected: []string{".hidden/hidden.go", "_tools/tools.go", "api/api.go", "api/api.pb.go", "api/build/build.go",
+				"api/keep_mock.go", "api/service_mock.go", "build/out.go", "internal/store/db.go", "main.go", "main_test.go",
+				"testdata/fixture.go"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parsedFiles(test.opts); strings.Join(got, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("Expected files %v, got %v", test.expected, got)
+			}
+		})
+	}
+
+	if _, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{Exclude: []string{"[unclosed"}}); err == nil {
+		t.Error("Expected an error for an invalid glob")
+	}
+}
+
+func TestParseGoProjectBuildConstraints(t *testing.T) {
+	files := map[string]string{
+		"go.mod":        "module example.com/app\n\ngo 1.22\n",
+		"fs.go":         "package app\n",
+		"fs_linux.go":   "package app\n\ntype FS struct{ fd int }\n",
+		"fs_windows.go": "package app\n\ntype FS struct{ handle uintptr }\n",
+		"fs_other.go":   "//go:build !linux && !windows\n\npackage app\n\ntype FS struct{}\n",
+		"fs_arm64.go":   "package app\n",
+		"debug.go":      "//go:build debug\n\npackage app\n",
+	}
+	tmpDir := writeTestProject(t, files)
+
+	tests := []struct {
+		opts     ParseOptions
+		expected []string
+	}{
+		{ParseOptions{GOOS: "linux", GOARCH: "amd64"}, []string{"fs.go", "fs_linux.go"}},
+		{ParseOptions{GOOS: "windows", GOARCH: "arm64"}, []string{"fs.go", "fs_arm64.go", "fs_windows.go"}},
+		{ParseOptions{GOOS: "darwin", GOARCH: "amd64", BuildTags: []string{"debug"}}, []string{"debug.go", "fs.go", "fs_other.go"}},
+	}
+	for _, test := range tests {
+		projectData, err := ParseGoProject(context.Background(), tmpDir, test.opts)
+		if err != nil {
+			t.Fatalf("Failed to parse project: %v", err)
+		}
+		var names []string
+		for path := range projectData.Files {
+			names = append(names, filepath.Base(path))
+		}
+		sort.Strings(names)
+		if strings.Join(names, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Expected %v for %s/%s %v, got %v", test.expected, test.opts.GOOS, test.opts.GOARCH, test.opts.BuildTags, names)
+		}
+	}
+}
+
+func TestParseGoProjectModules(t *testing.T) {
+	files := map[string]string{
+		"go.work":              "go 1.22\n\nuse (\n\t.\n\t./api\n)\n",
+		"go.mod":               "module example.com/app\n\ngo 1.22\n\nrequire example.com/app/api v0.0.0\n",
+		"main.go":              "package main\n\nimport \"example.com/app/api/client\"\n",
+		"api/go.mod":           "module example.com/app/api\n\ngo 1.22\n",
+		"api/client/client.go": "package client\n",
+		"tools/go.mod":         "module example.com/tools\n\ngo 1.22\n\nrequire example.com/app v0.0.0\n",
+		"tools/gen/gen.go":     "package gen\n\nimport \"example.com/app/api/client\"\n",
+	}
+	tmpDir := writeTestProject(t, files)
+
+	projectData, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{})
+	if err != nil {
+		t.Fatalf("Failed to parse project: %v", err)
+	}
+
+	var modulePaths []string
+	for _, module := range projectData.Modules {
+		modulePaths = append(modulePaths, module.Path)
+	}
+	expectedModules := []string{"example.com/app", "example.com/app/api", "example.com/tools"}
+	if strings.Join(modulePaths, ",") != strings.Join(expectedModules, ",") {
+		t.Fatalf("Expected modules %v, got %v", expectedModules, modulePaths)
+	}
+	if projectData.Workspace == nil || strings.Join(projectData.Workspace.Use, ",") != ".,./api" {
+		t.Errorf("Unexpected workspace: %+v", projectData.Workspace)
+	}
+
+	packageModules := map[string]string{
+		"example.com/app":            "example.com/app",
+		"example.com/app/api/client": "example.com/app/api",
+		"example.com/tools/gen":      "example.com/tools",
+	}
+	for importPath, want := range packageModules {
+		pkg, ok := projectData.Packages[importPath]
+		if !ok {
+			t.Errorf("Package %s not found, got %v", importPath, projectData.Packages)
+			continue
+		}
+		if pkg.Module != want {
+			t.Errorf("Expected package %s in module %s, got %s", importPath, want, pkg.Module)
+		}
+	}
+
+	// The nested module wins over its parent module
+	if module := projectData.ModuleOf("example.com/app/api/client"); module == nil || module.Path != "example.com/app/api" {
+		t.Errorf("Unexpected module of example.com/app/api/client: %+v", module)
+	}
+	if module := projectData.ModuleOf("github.com/spf13/cobra"); module != nil {
+		t.Errorf("Expected no module for a third-party import, got %+v", module)
+	}
+	if kind := projectData.ClassifyImport("example.com/tools/gen"); kind != ImportInternal {
+		t.Errorf("Expected a package of a nested module to be internal, got %s", kind)
+	}
+
+	view := projectData.ModuleProject("example.com/tools")
+	if view == nil {
+		t.Fatal("Expected a view of module example.com/tools")
+	}
+	if len(view.Packages) != 1 || view.Packages["example.com/tools/gen"] == nil || len(view.Files) != 1 {
+		t.Errorf("Expected only the gen package in the module view, got %v", view.Packages)
+	}
+	if kind := view.ClassifyImport("example.com/app/api/client"); kind != ImportExternal {
+		t.Errorf("Expected other modules to be external in a module view, got %s", kind)
+	}
+	if projectData.ModuleProject("example.com/missing") != nil {
+		t.Error("Expected no view of an unknown module")
+	}
+}
+
+func TestParseGoProjectRelativePaths(t *testing.T) {
+	files := map[string]string{
+		"go.mod":            "module example.com/app\n\ngo 1.22\n",
+		"main.go":           "package main\n",
+		"internal/db/db.go": "package db\n",
+	}
+
+	// The same project checked out in two places parses to the same paths
+	var parsed []*RawProjectData
+	for i := 0; i < 2; i++ {
+		tmpDir := writeTestProject(t, files)
+		projectData, err := ParseGoProject(context.Background(), tmpDir, ParseOptions{})
+		if err != nil {
+			t.Fatalf("Failed to parse project: %v", err)
+		}
+		if projectData.Root != tmpDir || projectData.ModulePath != "example.com/app" {
+			t.Errorf("Unexpected root %s and module path %s", projectData.Root, projectData.ModulePath)
+		}
+		parsed = append(parsed, projectData)
+	}
+
+	for _, projectData := range parsed {
+		var paths []string
+		for path := range projectData.Files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		if strings.Join(paths, ",") != "internal/db/db.go,main.go" {
+			t.Errorf("Expected repository relative paths, got %v", paths)
+		}
+		if pkg := projectData.Packages["example.com/app/internal/db"]; pkg == nil || pkg.Dir != "internal/db" {
+			t.Errorf("Unexpected db package %+v", pkg)
+		}
+		if projectData.Module.Dir != "." {
+			t.Errorf("Expected the root module in \".\", got %s", projectData.Module.Dir)
+		}
+		if abs := projectData.AbsPath("internal/db/db.go"); abs != filepath.Join(projectData.Root, "internal", "db", "db.go") {
+			t.Errorf("Unexpected absolute path %s", abs)
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+)
+
+// Position records where a declaration appears in its source file.
+// Lines are 1-based, byte offsets are 0-based and end-exclusive.
+type Position struct {
+	StartByte uint32
+	EndByte   uint32
+	StartLine int
+	EndLine   int
+}
+
+// Import is a single import spec
+type Import struct {
+	Name string // Explicit package name ("_", "." or an alias), empty if not renamed
+	Path string
+	Position
+}
+
+// TypeKind describes what a type declaration declares
+type TypeKind string
+
+const (
+	TypeKindStruct    TypeKind = "struct"
+	TypeKindInterface TypeKind = "interface"
+	TypeKindAlias     TypeKind = "alias" // type A = B
+	TypeKindNamed     TypeKind = "named" // type A B, where B is not a struct or interface
+)
+
+// TypeDecl is a top-level type declaration
+type TypeDecl struct {
+	Name       string
+	Kind       TypeKind
+	TypeParams string   // Raw type parameter list, e.g. "[T any]"
+	Underlying string   // Source text of the type expression for aliases and named types
+	Fields     []Field  // Struct fields, in declaration order
+	Methods    []Func   // Interface methods, in declaration order
+	Embeds     []string // Embedded interfaces and type set elements of an interface
+	Doc        string
+	Position
+}
+
+// Field is a single struct field. A declaration such as "x, y int"
+// yields one Field per name.
+type Field struct {
+	Name     string // For embedded fields, the unqualified type name
+	Type     string
+	Tag      string // Raw tag literal including quotes, empty if absent
+	Embedded bool
+	Position
+}
+
+// Param is a single function parameter or result
+type Param struct {
+	Name string // Empty for unnamed parameters
+	Type string // Variadic parameters are prefixed with "..."
+}
+
+// Receiver describes the receiver of a method
+type Receiver struct {
+	Name    string
+	Type    string // Base type name without pointer or type arguments
+	Pointer bool
+}
+
+// Func is a top-level function, a method or an interface method
+type Func struct {
+	Name       string
+	Receiver   *Receiver // Nil for functions and interface methods
+	TypeParams string
+	Params     []Param
+	Results    []Param
+	Calls      []Call // Calls made in the body, in evaluation order
+	Doc        string
+	Position
+}
+
+// Call is a call expression inside a function body. For "a.b.Do()" the
+// qualifier is "a.b" and the name is "Do".
+type Call struct {
+	Qualifier string // Empty for plain calls and calls on non-identifier operands
+	Name      string
+	Dynamic   bool // The callee is not a plain or selector expression, e.g. a call result
+
+	// Target is the callee resolved from type information. It is nil
+	// unless the project was parsed with BackendPackages.
+	Target *CallTarget `json:",omitempty"`
+	Position
+}
+
+// Value is a single constant or variable. A spec such as "a, b = 1, 2"
+// yields one Value per name.
+type Value struct {
+	Name  string
+	Type  string // Empty when the type is inferred
+	Value string // Source text of the initializer, empty if absent
+	Doc   string
+	Position
+}
+
+// extractSymbols walks the top-level declarations of a parsed file and
+// fills in the structured symbol records of fileData
+func extractSymbols(root *sitter.Node, content []byte, fileData *FileData) {
+	childCount := int(root.NamedChildCount())
+	for i := 0; i < childCount; i++ {
+		node := root.NamedChild(i)
+		if node == nil {
+			continue
+		}
+
+		switch node.Type() {
+		case "import_declaration":
+			for _, spec := range findAllNodesOfType(node, "import_spec") {
+				fileData.Imports = append(fileData.Imports, extractImport(spec, content))
+			}
+		case "type_declaration":
+			fileData.Types = append(fileData.Types, extractTypeDeclaration(node, content)...)
+		case "function_declaration":
+			fileData.Functions = append(fileData.Functions, extractFunc(node, content))
+		case "method_declaration":
+			fileData.Methods = append(fileData.Methods, extractFunc(node, content))
+		case "const_declaration":
+			fileData.Consts = append(fileData.Consts, extractValues(node, "const_spec", content)...)
+		case "var_declaration":
+			fileData.Vars = append(fileData.Vars, extractValues(node, "var_spec", content)...)
+		}
+	}
+}
+
+func extractImport(spec *sitter.Node, content []byte) Import {
+	imp := Import{Position: nodePosition(spec)}
+	if name := spec.ChildByFieldName("name"); name != nil {
+		imp.Name = nodeText(name, content)
+	}
+	if path := spec.ChildByFieldName("path"); path != nil {
+		imp.Path = unquote(nodeText(path, content))
+	}
+	return imp
+}
+
+// extractTypeDeclaration handles both "type A B" and grouped "type ( ... )" forms
+func extractTypeDeclaration(decl *sitter.Node, content []byte) []TypeDecl {
+	var types []TypeDecl
+
+	specCount := int(decl.NamedChildCount())
+	for i := 0; i < specCount; i++ {
+		spec := decl.NamedChild(i)
+		if spec == nil || (spec.Type() != "type_spec" && spec.Type() != "type_alias") {
+			continue
+		}
+
+		typeDecl := TypeDecl{
+			Doc:      docComment(spec, content),
+			Position: nodePosition(spec),
+		}
+		// Ungrouped declarations carry their doc comment on the declaration itself
+		if typeDecl.Doc == "" && specCount == 1 {
+			typeDecl.Doc = docComment(decl, content)
+		}
+		if name := spec.ChildByFieldName("name"); name != nil {
+			typeDecl.Name = nodeText(name, content)
+		}
+		if params := spec.ChildByFieldName("type_parameters"); params != nil {
+			typeDecl.TypeParams = nodeText(params, content)
+		}
+
+		typeNode := spec.ChildByFieldName("type")
+		switch {
+		case spec.Type() == "type_alias":
+			typeDecl.Kind = TypeKindAlias
+			typeDecl.Underlying = nodeText(typeNode, content)
+		case typeNode != nil && typeNode.Type() == "struct_type":
+			typeDecl.Kind = TypeKindStruct
+			typeDecl.Fields = extractFields(typeNode, content)
+		case typeNode != nil && typeNode.Type() == "interface_type":
+			typeDecl.Kind = TypeKindInterface
+			typeDecl.Methods, typeDecl.Embeds = extractInterfaceElems(typeNode, content)
+		default:
+			typeDecl.Kind = TypeKindNamed
+			typeDecl.Underlying = nodeText(typeNode, content)
+		}
+
+		types = append(types, typeDecl)
+	}
+
+	return types
+}
+
+func extractFields(structType *sitter.Node, content []byte) []Field {
+	var fields []Field
+
+	for _, decl := range findAllNodesOfType(structType, "field_declaration") {
+		// Skip fields of nested anonymous structs, they belong to their own struct type
+		if owner := nearestAncestorOfType(decl, "struct_type"); owner == nil || !owner.Equal(structType) {
+			continue
+		}
+
+		typeNode := decl.ChildByFieldName("type")
+		typeText := nodeText(typeNode, content)
+		tag := ""
+		if tagNode := decl.ChildByFieldName("tag"); tagNode != nil {
+			tag = nodeText(tagNode, content)
+		}
+
+		names := childrenByFieldName(decl, "name")
+		if len(names) == 0 {
+			// Embedded field: the pointer marker is an anonymous token, not part of the type node
+			if firstChild := decl.Child(0); firstChild != nil && firstChild.Type() == "*" {
+				typeText = "*" + typeText
+			}
+			fields = append(fields, Field{
+				Name:     embeddedFieldName(typeText),
+				Type:     typeText,
+				Tag:      tag,
+				Embedded: true,
+				Position: nodePosition(decl),
+			})
+			continue
+		}
+
+		for _, name := range names {
+			fields = append(fields, Field{
+				Name:     nodeText(name, content),
+				Type:     typeText,
+				Tag:      tag,
+				Position: nodePosition(decl),
+			})
+		}
+	}
+
+	return fields
+}
+
+func extractInterfaceElems(interfaceType *sitter.Node, content []byte) ([]Func, []string) {
+	var methods []Func
+	var embeds []string
+
+	childCount := int(interfaceType.NamedChildCount())
+	for i := 0; i < childCount; i++ {
+		elem := interfaceType.NamedChild(i)
+		if elem == nil {
+			continue
+		}
+
+		switch elem.Type() {
+		case "method_elem", "method_spec":
+			method := Func{
+				Doc:      docComment(elem, content),
+				Position: nodePosition(elem),
+			}
+			if name := elem.ChildByFieldName("name"); name != nil {
+				method.Name = nodeText(name, content)
+			}
+			method.Params = extractParams(elem.ChildByFieldName("parameters"), content)
+			method.Results = extractResults(elem.ChildByFieldName("result"), content)
+			methods = append(methods, method)
+		case "type_elem", "constraint_elem":
+			embeds = append(embeds, nodeText(elem, content))
+		}
+	}
+
+	return methods, embeds
+}
+
+// extractFunc handles both function and method declarations
+func extractFunc(decl *sitter.Node, content []byte) Func {
+	fn := Func{
+		Doc:      docComment(decl, content),
+		Position: nodePosition(decl),
+	}
+	if name := decl.ChildByFieldName("name"); name != nil {
+		fn.Name = nodeText(name, content)
+	}
+	if params := decl.ChildByFieldName("type_parameters"); params != nil {
+		fn.TypeParams = nodeText(params, content)
+	}
+	if receiver := decl.ChildByFieldName("receiver"); receiver != nil {
+		fn.Receiver = extractReceiver(receiver, content)
+	}
+	fn.Params = extractParams(decl.ChildByFieldName("parameters"), content)
+	fn.Results = extractResults(decl.ChildByFieldName("result"), content)
+	if body := decl.ChildByFieldName("body"); body != nil {
+		fn.Calls = extractCalls(body, content)
+	}
+	return fn
+}
+
+// extractCalls collects the call expressions in a function body. Calls are
+// ordered by where they end, so arguments and receivers such as g in
+// "f(g())" or Foo in "x.Foo().Bar()" come before the call that uses them.
+func extractCalls(body *sitter.Node, content []byte) []Call {
+	var calls []Call
+
+	for _, callNode := range findAllNodesOfType(body, "call_expression") {
+		function := callNode.ChildByFieldName("function")
+		if function == nil {
+			continue
+		}
+
+		call := Call{Position: nodePosition(callNode)}
+		switch function.Type() {
+		case "identifier":
+			call.Name = nodeText(function, content)
+		case "selector_expression":
+			call.Name = nodeText(function.ChildByFieldName("field"), content)
+			operand := function.ChildByFieldName("operand")
+			if isIdentifierChain(operand) {
+				call.Qualifier = nodeText(operand, content)
+			} else {
+				call.Dynamic = true
+			}
+		default:
+			// Function literals, index expressions, conversions to parenthesized types
+			continue
+		}
+
+		calls = append(calls, call)
+	}
+
+	sort.SliceStable(calls, func(i, j int) bool {
+		return calls[i].EndByte < calls[j].EndByte
+	})
+
+	return calls
+}
+
+// isIdentifierChain reports whether node is an identifier or a chain of
+// field selections on one, such as "s.store.db"
+func isIdentifierChain(node *sitter.Node) bool {
+	for node != nil {
+		switch node.Type() {
+		case "identifier":
+			return true
+		case "selector_expression":
+			node = node.ChildByFieldName("operand")
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func extractReceiver(receiver *sitter.Node, content []byte) *Receiver {
+	param := findFirstChildOfType(receiver, "parameter_declaration")
+	if param == nil {
+		return &Receiver{}
+	}
+
+	recv := &Receiver{}
+	if name := param.ChildByFieldName("name"); name != nil {
+		recv.Name = nodeText(name, content)
+	}
+
+	typeNode := param.ChildByFieldName("type")
+	if typeNode != nil && typeNode.Type() == "pointer_type" {
+		recv.Pointer = true
+		typeNode = typeNode.NamedChild(0)
+	}
+	if typeNode != nil && typeNode.Type() == "generic_type" {
+		typeNode = typeNode.ChildByFieldName("type")
+	}
+	recv.Type = nodeText(typeNode, content)
+
+	return recv
+}
+
+func extractParams(list *sitter.Node, content []byte) []Param {
+	if list == nil {
+		return nil
+	}
+
+	var params []Param
+	childCount := int(list.NamedChildCount())
+	for i := 0; i < childCount; i++ {
+		decl := list.NamedChild(i)
+		if decl == nil {
+			continue
+		}
+
+		typeText := nodeText(decl.ChildByFieldName("type"), content)
+		switch decl.Type() {
+		case "variadic_parameter_declaration":
+			typeText = "..." + typeText
+		case "parameter_declaration":
+		default:
+			continue
+		}
+
+		names := childrenByFieldName(decl, "name")
+		if len(names) == 0 {
+			params = append(params, Param{Type: typeText})
+			continue
+		}
+		for _, name := range names {
+			params = append(params, Param{Name: nodeText(name, content), Type: typeText})
+		}
+	}
+
+	return params
+}
+
+// extractResults handles both a single unnamed result type and a parenthesized result list
+func extractResults(result *sitter.Node, content []byte) []Param {
+	if result == nil {
+		return nil
+	}
+	if result.Type() == "parameter_list" {
+		return extractParams(result, content)
+	}
+	return []Param{{Type: nodeText(result, content)}}
+}
+
+func extractValues(decl *sitter.Node, specType string, content []byte) []Value {
+	var values []Value
+
+	for _, spec := range findAllNodesOfType(decl, specType) {
+		// Skip specs declared inside function literals of an initializer
+		if owner := nearestAncestorOfType(spec, decl.Type()); owner == nil || !owner.Equal(decl) {
+			continue
+		}
+
+		typeText := nodeText(spec.ChildByFieldName("type"), content)
+
+		var initializers []string
+		if valueNode := spec.ChildByFieldName("value"); valueNode != nil {
+			count := int(valueNode.NamedChildCount())
+			for i := 0; i < count; i++ {
+				initializers = append(initializers, nodeText(valueNode.NamedChild(i), content))
+			}
+		}
+
+		doc := docComment(spec, content)
+		if doc == "" && decl.NamedChildCount() == 1 && spec.Parent().Equal(decl) {
+			doc = docComment(decl, content)
+		}
+
+		for i, name := range childrenByFieldName(spec, "name") {
+			value := Value{
+				Name:     nodeText(name, content),
+				Type:     typeText,
+				Doc:      doc,
+				Position: nodePosition(spec),
+			}
+			if i < len(initializers) {
+				value.Value = initializers[i]
+			}
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// docComment returns the text of the comment lines directly above node
+func docComment(node *sitter.Node, content []byte) string {
+	var lines []string
+
+	expectedRow := node.StartPoint().Row
+	for prev := node.PrevNamedSibling(); prev != nil && prev.Type() == "comment"; prev = prev.PrevNamedSibling() {
+		if prev.EndPoint().Row+1 != expectedRow {
+			break
+		}
+		lines = append([]string{nodeText(prev, content)}, lines...)
+		expectedRow = prev.StartPoint().Row
+	}
+
+	for i, line := range lines {
+		line = strings.TrimPrefix(line, "//")
+		line = strings.TrimPrefix(line, "/*")
+		line = strings.TrimSuffix(line, "*/")
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// embeddedFieldName returns the implicit field name of an embedded type,
+// e.g. "*io.Reader" -> "Reader", "List[T]" -> "List"
+func embeddedFieldName(typeText string) string {
+	name := strings.TrimPrefix(typeText, "*")
+	if idx := strings.Index(name, "["); idx != -1 {
+		name = name[:idx]
+	}
+	if idx := strings.LastIndex(name, "."); idx != -1 {
+		name = name[idx+1:]
+	}
+	return name
+}
+
+func nodeText(node *sitter.Node, content []byte) string {
+	if node == nil {
+		return ""
+	}
+	return string(content[node.StartByte():node.EndByte()])
+}
+
+func nodePosition(node *sitter.Node) Position {
+	return Position{
+		StartByte: node.StartByte(),
+		EndByte:   node.EndByte(),
+		StartLine: int(node.StartPoint().Row) + 1,
+		EndLine:   int(node.EndPoint().Row) + 1,
+	}
+}
+
+func unquote(literal string) string {
+	return strings.Trim(literal, "\"`")
+}
+
+func childrenByFieldName(node *sitter.Node, fieldName string) []*sitter.Node {
+	var children []*sitter.Node
+	childCount := int(node.ChildCount())
+	for i := 0; i < childCount; i++ {
+		// Separators between repeated fields can carry the field name too
+		if child := node.Child(i); child != nil && child.IsNamed() && node.FieldNameForChild(i) == fieldName {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func nearestAncestorOfType(node *sitter.Node, nodeType string) *sitter.Node {
+	for parent := node.Parent(); parent != nil; parent = parent.Parent() {
+		if parent.Type() == nodeType {
+			return parent
+		}
+	}
+	return nil
+}